@@ -8,6 +8,7 @@ import (
 	"github.com/teragrep/rlp_05/pkg/RelpConnection"
 	"github.com/teragrep/rlp_05/pkg/RelpDialer"
 	"log"
+	"os"
 	"time"
 )
 
@@ -18,6 +19,18 @@ func main() {
 	relpSess := RelpConnection.RelpConnection{RelpDialer: &RelpDialer.RelpTLSDialer{}}
 	relpSess.Init()
 	relpSess.TlsConfig = &tls.Config{InsecureSkipVerify: true}
+	if os.Getenv("RELP_CA_FILE") != "" {
+		// verify the server, and authenticate with the client certificate if given
+		tlsFiles := &RelpDialer.RelpTLSFiles{
+			CAFile:   os.Getenv("RELP_CA_FILE"),
+			CertFile: os.Getenv("RELP_CERT_FILE"),
+			KeyFile:  os.Getenv("RELP_KEY_FILE"),
+		}
+		if err := tlsFiles.Init(); err != nil {
+			log.Fatalf("Invalid TLS files: %v", err)
+		}
+		relpSess.TlsConfig = tlsFiles.Config()
+	}
 	batch := RelpBatch.RelpBatch{}
 	batch.Init()
 	batch.PutRequest(&RelpFrame.TX{
@@ -28,23 +41,16 @@ func main() {
 		},
 	})
 
-	notDone := true
+	// failed commits reconnect and resend the unacknowledged requests
+	relpSess.ReconnectPolicy = &RelpConnection.ReconnectPolicy{}
+	relpSess.ReconnectPolicy.Init()
 
 	retry(&relpSess)
 	fmt.Println("Continuing committing after 5 sec")
 	time.Sleep(5 * time.Second)
-	for notDone {
-		commitErr := relpSess.Commit(&batch)
-		if commitErr != nil {
-			log.Printf("Error committing batch: '%v'\n", commitErr.Error())
-		}
-
-		if !batch.VerifyTransactionAll() {
-			batch.RetryAllFailed()
-			retry(&relpSess)
-		} else {
-			notDone = false
-		}
+	commitErr := relpSess.Commit(&batch)
+	if commitErr != nil {
+		log.Printf("Error committing batch: '%v'\n", commitErr.Error())
 	}
 
 	relpSess.Disconnect()
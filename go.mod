@@ -1,3 +1,3 @@
 module github.com/teragrep/rlp_05
 
-go 1.19
+go 1.21
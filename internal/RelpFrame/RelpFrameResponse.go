@@ -0,0 +1,46 @@
+package RelpFrame
+
+import (
+	"bytes"
+	"github.com/teragrep/rlp_05/pkg/Errors"
+	"strings"
+)
+
+// Response is the parsed data of a rsp frame; the status line "code SP text" followed by optional data lines,
+// e.g. the offers of the server in the response to the open command
+type Response struct {
+	Code  int
+	Text  string
+	Lines []string
+}
+
+// ParseResponse parses the data of the response frame. The code must be three digits between 100 and 599,
+// and may be followed by the text after a SP. The data after the status line is split into Lines,
+// ignoring the trailing newline.
+func (rxFrame *RX) ParseResponse() (*Response, error) {
+	statusLine, rest, hasLines := bytes.Cut(rxFrame.Data, []byte{NL})
+	codeBytes, text, _ := bytes.Cut(statusLine, []byte{SP})
+	if len(codeBytes) == 0 {
+		return nil, &Errors.ResponseCodeParsingError{Reason: "response code could not been found"}
+	}
+	if len(codeBytes) > 3 {
+		return nil, &Errors.ResponseCodeParsingError{Reason: "response code was longer than 3 numbers; want 3"}
+	}
+
+	code := 0
+	for _, v := range codeBytes {
+		if v < '0' || v > '9' {
+			return nil, &Errors.ResponseCodeParsingError{Reason: "encountered non-number ASCII char in response code"}
+		}
+		code = code*10 + int(v-'0')
+	}
+	if len(codeBytes) < 3 || code < 100 || code > 599 {
+		return nil, &Errors.ResponseCodeParsingError{Reason: "response code was not between 100 and 599"}
+	}
+
+	response := &Response{Code: code, Text: string(text)}
+	if hasLines && len(rest) > 0 {
+		response.Lines = strings.Split(strings.TrimSuffix(string(rest), "\n"), "\n")
+	}
+	return response, nil
+}
@@ -2,7 +2,6 @@ package RelpFrame
 
 import (
 	"bytes"
-	"log"
 	"strconv"
 )
 
@@ -23,7 +22,6 @@ func (txFrame *TX) Write(byteBuf *bytes.Buffer) (int, error) {
 	dataLenBytes := []byte(strconv.FormatUint(uint64(txFrame.DataLength), 10))
 	bytesWritten := 0
 
-	log.Println("RelpFrameTX: Start writing to buffer...")
 	// transaction id
 	nId, errId := byteBuf.Write(idBytes)
 	if errId != nil {
@@ -84,7 +82,5 @@ func (txFrame *TX) Write(byteBuf *bytes.Buffer) (int, error) {
 		bytesWritten += 1
 	}
 
-	log.Printf("RelpFrameTX: Wrote %v byte(s) to buffer\n", bytesWritten)
-
 	return bytesWritten, nil
 }
@@ -0,0 +1,12 @@
+package RelpLog
+
+import "log/slog"
+
+// OrDefault returns the given logger, or slog.Default() if the logger has not been set.
+// The default logger discards debug level records, which silences the per-frame tracing.
+func OrDefault(logger *slog.Logger) *slog.Logger {
+	if logger == nil {
+		return slog.Default()
+	}
+	return logger
+}
@@ -2,27 +2,35 @@ package RelpParser
 
 import (
 	"bytes"
-	"github.com/teragrep/rlp_05/internal/Errors"
 	"github.com/teragrep/rlp_05/internal/RelpCommand"
-	"log"
+	"github.com/teragrep/rlp_05/internal/RelpLog"
+	"github.com/teragrep/rlp_05/pkg/Errors"
+	"log/slog"
 	"strconv"
 	"strings"
 )
 
-// constants, such as parser state (PS_ prefix) and max command length (MAX_CMD_LEN)
+// constants, such as parser state (PS_ prefix), max command length (MAX_CMD_LEN), max digits of the txnId
+// and length (MAX_TXN_LEN, MAX_LEN_LEN) and the default max data length of a frame (DEFAULT_MAX_FRAME_LEN)
 const (
-	MAX_CMD_LEN = 11
-	PS_TXN      = 0
-	PS_CMD      = 1
-	PS_LEN      = 2
-	PS_DATA     = 3
-	PS_NL       = 4
+	MAX_CMD_LEN           = 11
+	MAX_TXN_LEN           = 9
+	MAX_LEN_LEN           = 9
+	DEFAULT_MAX_FRAME_LEN = 16 * 1024 * 1024
+	PS_TXN                = 0
+	PS_CMD                = 1
+	PS_LEN                = 2
+	PS_DATA               = 3
+	PS_NL                 = 4
 )
 
 // RelpParser contains the fields necessary for completing the response (RX)
 // parsing. The results of the parse operation can be found from the frameTxnId, frameCmdString, frameLen
-// and frameData fields.
+// and frameData fields. Completed frames are traced to the Logger on debug level.
+// Frames with more than MaxFrameLen bytes of data are rejected, DEFAULT_MAX_FRAME_LEN is used if it is not set.
 type RelpParser struct {
+	Logger           *slog.Logger
+	MaxFrameLen      int
 	state            int
 	IsComplete       bool
 	frameTxnIdString string
@@ -51,6 +59,11 @@ func (parser *RelpParser) Parse(b byte) error {
 					parser.FrameTxnId = num
 					parser.state = PS_CMD
 				}
+			} else if len(parser.frameTxnIdString) >= MAX_TXN_LEN {
+				return &Errors.ResponseParsingError{
+					Position: "txn",
+					Reason:   "frameTxnId is longer than " + strconv.Itoa(MAX_TXN_LEN) + " digits",
+				}
 			} else {
 				parser.frameTxnIdString += string(b)
 			}
@@ -72,6 +85,11 @@ func (parser *RelpParser) Parse(b byte) error {
 						Reason:   "invalid command",
 					}
 				}
+			} else if len(parser.FrameCmdString) >= MAX_CMD_LEN {
+				return &Errors.ResponseParsingError{
+					Position: "cmd",
+					Reason:   "command is longer than " + strconv.Itoa(MAX_CMD_LEN) + " characters",
+				}
 			} else {
 				parser.FrameCmdString += string(b)
 			}
@@ -97,9 +115,17 @@ func (parser *RelpParser) Parse(b byte) error {
 						Reason:   "frame length must be of size 0 or larger",
 					}
 				}
+				if parser.FrameLen > parser.maxFrameLen() {
+					return &Errors.ResponseParsingError{
+						Position: "len",
+						Reason: "frame length " + strconv.Itoa(parser.FrameLen) + " exceeds the maximum of " +
+							strconv.Itoa(parser.maxFrameLen()),
+					}
+				}
 
+				// the buffer grows with the received data, the length sent by the peer is not allocated up-front
 				parser.frameLenLeft = parser.FrameLen
-				parser.FrameData = bytes.NewBuffer(make([]byte, 0, parser.FrameLen))
+				parser.FrameData = &bytes.Buffer{}
 
 				// length bytes done, move to next stage
 				if parser.FrameLen == 0 {
@@ -115,6 +141,11 @@ func (parser *RelpParser) Parse(b byte) error {
 						parser.IsComplete = true
 					}
 				}
+			} else if len(parser.frameLenString) >= MAX_LEN_LEN {
+				return &Errors.ResponseParsingError{
+					Position: "len",
+					Reason:   "frame length is longer than " + strconv.Itoa(MAX_LEN_LEN) + " digits",
+				}
 			} else {
 				parser.frameLenString += string(b)
 			}
@@ -143,10 +174,11 @@ func (parser *RelpParser) Parse(b byte) error {
 			parser.IsComplete = true
 			if b == '\n' {
 				// RELP msg always ends with NL
-				log.Printf("RelpParser: Parser complete. Got: %v %v %v %v\n",
-					parser.FrameTxnId, parser.FrameCmdString, parser.FrameLen, parser.FrameData)
+				RelpLog.OrDefault(parser.Logger).Debug("RelpParser: Parser complete",
+					"txnId", parser.FrameTxnId, "cmd", parser.FrameCmdString, "len", parser.FrameLen)
 			} else {
-				log.Println("RelpParser: Final byte was not NL, completed.")
+				RelpLog.OrDefault(parser.Logger).Warn("RelpParser: Final byte was not NL, completed",
+					"txnId", parser.FrameTxnId, "cmd", parser.FrameCmdString)
 			}
 			break
 		}
@@ -157,3 +189,11 @@ func (parser *RelpParser) Parse(b byte) error {
 	}
 	return nil
 }
+
+// maxFrameLen returns the MaxFrameLen, or DEFAULT_MAX_FRAME_LEN if it has not been set
+func (parser *RelpParser) maxFrameLen() int {
+	if parser.MaxFrameLen <= 0 {
+		return DEFAULT_MAX_FRAME_LEN
+	}
+	return parser.MaxFrameLen
+}
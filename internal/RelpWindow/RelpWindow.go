@@ -2,52 +2,127 @@ package RelpWindow
 
 import (
 	"errors"
-	"log"
+	"github.com/teragrep/rlp_05/internal/RelpLog"
+	"github.com/teragrep/rlp_05/pkg/RelpBatch"
+	"log/slog"
+	"sync"
 )
 
+// PendingRequest contains the batch-specific request id and the batch that owns the request
+type PendingRequest struct {
+	RequestId uint64
+	Batch     *RelpBatch.RelpBatch
+}
+
 // RelpWindow is a struct that contains all the ids (frame id->frame?) mapped
-// As the "pending" name suggests, they are the transactions still in progress
+// As the "pending" name suggests, they are the transactions still in progress.
+// The window is safe to use from the sending goroutines and the reading goroutine at the same time.
 type RelpWindow struct {
-	Pending map[uint64]uint64
+	Logger  *slog.Logger
+	pending map[uint64]*PendingRequest
+	mutex   sync.Mutex
 }
 
 // Init initializes the pending map
 func (win *RelpWindow) Init() *RelpWindow {
-	win.Pending = make(map[uint64]uint64)
+	win.mutex.Lock()
+	defer win.mutex.Unlock()
+	win.pending = make(map[uint64]*PendingRequest)
 	return win
 }
 
-// PutPending inserts an id-id pair to the map
-func (win *RelpWindow) PutPending(txnId, reqId uint64) {
-	it, has := win.Pending[txnId]
+// PutPending inserts the request of the batch as pending for the txnId
+func (win *RelpWindow) PutPending(txnId, reqId uint64, batch *RelpBatch.RelpBatch) {
+	win.mutex.Lock()
+	defer win.mutex.Unlock()
+	it, has := win.pending[txnId]
 	if has {
-		log.Println("Pending had for txnId: ", txnId, it)
+		RelpLog.OrDefault(win.Logger).Warn("Pending had for txnId", "txnId", txnId, "reqId", it.RequestId)
 	}
-	win.Pending[txnId] = reqId
+	win.pending[txnId] = &PendingRequest{RequestId: reqId, Batch: batch}
 }
 
 // IsPending checks if a transaction is pending
 func (win *RelpWindow) IsPending(txnId uint64) bool {
-	_, ok := win.Pending[txnId]
+	win.mutex.Lock()
+	defer win.mutex.Unlock()
+	_, ok := win.pending[txnId]
 	return ok
 }
 
-// GetPending gets the requestId for the specified transactionId
-func (win *RelpWindow) GetPending(txnId uint64) (uint64, error) {
-	v, ok := win.Pending[txnId]
+// GetPending gets the pending request for the specified transactionId
+func (win *RelpWindow) GetPending(txnId uint64) (*PendingRequest, error) {
+	win.mutex.Lock()
+	defer win.mutex.Unlock()
+	v, ok := win.pending[txnId]
 	if ok {
 		return v, nil
 	} else {
-		return 0, errors.New("txnId did not have a matching value in RelpWindow")
+		return nil, errors.New("txnId did not have a matching value in RelpWindow")
+	}
+}
+
+// TakePending gets the pending request for the specified transactionId and removes it from the map.
+// The boolean return value is false if the transaction was not pending.
+func (win *RelpWindow) TakePending(txnId uint64) (*PendingRequest, bool) {
+	win.mutex.Lock()
+	defer win.mutex.Unlock()
+	v, ok := win.pending[txnId]
+	if ok {
+		delete(win.pending, txnId)
 	}
+	return v, ok
+}
+
+// TakeAll gets all the pending requests and empties the map
+func (win *RelpWindow) TakeAll() []*PendingRequest {
+	win.mutex.Lock()
+	defer win.mutex.Unlock()
+	all := make([]*PendingRequest, 0, len(win.pending))
+	for _, v := range win.pending {
+		all = append(all, v)
+	}
+	win.pending = make(map[uint64]*PendingRequest)
+	return all
+}
+
+// TakeBatch gets the pending requests of the batch and removes them from the map
+func (win *RelpWindow) TakeBatch(batch *RelpBatch.RelpBatch) []*PendingRequest {
+	win.mutex.Lock()
+	defer win.mutex.Unlock()
+	var taken []*PendingRequest
+	for txnId, v := range win.pending {
+		if v.Batch == batch {
+			taken = append(taken, v)
+			delete(win.pending, txnId)
+		}
+	}
+	return taken
 }
 
 // RemovePending removes a pending transaction from the map
 func (win *RelpWindow) RemovePending(txnId uint64) {
-	delete(win.Pending, txnId)
+	win.mutex.Lock()
+	defer win.mutex.Unlock()
+	delete(win.pending, txnId)
 }
 
 // Size returns the amount of pending ids in the map
 func (win *RelpWindow) Size() int {
-	return len(win.Pending)
+	win.mutex.Lock()
+	defer win.mutex.Unlock()
+	return len(win.pending)
+}
+
+// SizeOf returns the amount of pending ids owned by the batch
+func (win *RelpWindow) SizeOf(batch *RelpBatch.RelpBatch) int {
+	win.mutex.Lock()
+	defer win.mutex.Unlock()
+	size := 0
+	for _, v := range win.pending {
+		if v.Batch == batch {
+			size++
+		}
+	}
+	return size
 }
@@ -0,0 +1,186 @@
+package Errors
+
+import "fmt"
+
+type ResponseParsingError struct {
+	Position string
+	Reason   string
+}
+
+func (rpe *ResponseParsingError) Error() string {
+	return fmt.Sprintf("error occurred while parsing response at position %s, reason: %s",
+		rpe.Position, rpe.Reason)
+}
+
+type ResponseCodeParsingError struct {
+	Reason string
+}
+
+func (rcpe *ResponseCodeParsingError) Error() string {
+	return fmt.Sprintf("Error parsing response code: %s", rcpe.Reason)
+}
+
+type AckReadingError struct {
+	Reason string
+}
+
+func (are *AckReadingError) Error() string {
+	return fmt.Sprintf("ACK reading error: %s", are.Reason)
+}
+
+type ConnectionEstablishmentError struct {
+	Hostname  string
+	Port      int
+	Address   string
+	Reason    string
+	Encrypted bool
+	Protocol  string
+	Err       error
+}
+
+func (cee *ConnectionEstablishmentError) Error() string {
+	encryptedStr := "unencrypted"
+	if cee.Encrypted {
+		encryptedStr = "encrypted"
+	}
+	if cee.Address != "" {
+		return fmt.Sprintf("Could not establish %v connection to %v:%v (%v) using protocol %v for reason: %v",
+			encryptedStr, cee.Hostname, cee.Port, cee.Address, cee.Protocol, cee.Reason)
+	}
+	return fmt.Sprintf("Could not establish %v connection to %v:%v using protocol %v for reason: %v",
+		encryptedStr, cee.Hostname, cee.Port, cee.Protocol, cee.Reason)
+}
+
+func (cee *ConnectionEstablishmentError) Unwrap() error {
+	return cee.Err
+}
+
+type ServerCloseError struct {
+}
+
+func (sce *ServerCloseError) Error() string {
+	return "RELP server closed the connection"
+}
+
+type AbortError struct {
+}
+
+func (ae *AbortError) Error() string {
+	return "RELP connection was aborted"
+}
+
+type InvalidStateError struct {
+	Operation string
+	Current   string
+	Expected  string
+}
+
+func (ise *InvalidStateError) Error() string {
+	return fmt.Sprintf("Can't %v, connection was in state %v; expected %v", ise.Operation, ise.Current, ise.Expected)
+}
+
+type DialerNotSetError struct {
+}
+
+func (dnse *DialerNotSetError) Error() string {
+	return "RelpDialer has not been set! Please set as RelpTLSDialer or RelpPlainDialer." +
+		" Use the relpConnection.tlsConfig to configure the TLS connection!"
+}
+
+type ProtocolError struct {
+	Err error
+}
+
+func (pe *ProtocolError) Error() string {
+	return fmt.Sprintf("RELP protocol error: %v", pe.Err)
+}
+
+func (pe *ProtocolError) Unwrap() error {
+	return pe.Err
+}
+
+type SyslogFormatError struct {
+	Field  string
+	Reason string
+}
+
+func (sfe *SyslogFormatError) Error() string {
+	return fmt.Sprintf("Invalid syslog message field %v: %v", sfe.Field, sfe.Reason)
+}
+
+type OfferNegotiationError struct {
+	Reason string
+}
+
+func (one *OfferNegotiationError) Error() string {
+	return fmt.Sprintf("RELP offer negotiation failed: %v", one.Reason)
+}
+
+type NoHealthyEndpointError struct {
+	Err error
+}
+
+func (nhee *NoHealthyEndpointError) Error() string {
+	return fmt.Sprintf("No healthy RELP endpoint available, last error: %v", nhee.Err)
+}
+
+func (nhee *NoHealthyEndpointError) Unwrap() error {
+	return nhee.Err
+}
+
+type DialError struct {
+	Network string
+	Address string
+	Err     error
+}
+
+func (de *DialError) Error() string {
+	return fmt.Sprintf("Could not dial %v %v: %v", de.Network, de.Address, de.Err)
+}
+
+func (de *DialError) Unwrap() error {
+	return de.Err
+}
+
+type ProxyError struct {
+	Proxy  string
+	Reason string
+}
+
+func (pe *ProxyError) Error() string {
+	return fmt.Sprintf("Proxy %v could not connect: %v", pe.Proxy, pe.Reason)
+}
+
+type TLSConfigError struct {
+	File   string
+	Reason string
+}
+
+func (tce *TLSConfigError) Error() string {
+	return fmt.Sprintf("Invalid TLS configuration in %v: %v", tce.File, tce.Reason)
+}
+
+type PeerVerificationError struct {
+	Address string
+	Reason  string
+}
+
+func (pve *PeerVerificationError) Error() string {
+	return fmt.Sprintf("Could not verify the RELP server at %v: %v", pve.Address, pve.Reason)
+}
+
+type MessageDroppedError struct {
+	Reason string
+}
+
+func (mde *MessageDroppedError) Error() string {
+	return fmt.Sprintf("Message was dropped: %v", mde.Reason)
+}
+
+type ProducerConfigError struct {
+	Reason string
+}
+
+func (pce *ProducerConfigError) Error() string {
+	return fmt.Sprintf("Invalid producer configuration: %v", pce.Reason)
+}
@@ -0,0 +1,127 @@
+package RelpAdapter
+
+import (
+	"github.com/teragrep/rlp_05/internal/RelpLog"
+	"github.com/teragrep/rlp_05/pkg/RelpBatch"
+	"github.com/teragrep/rlp_05/pkg/RelpConnection"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// RelpBatcher collects syslog messages into a RelpBatch, and commits the batch over the Connection
+// once it has MaxBatchSize messages or MaxBatchBytes of payload, or FlushInterval has passed since the last commit.
+// The Connection must be connected before messages are added.
+type RelpBatcher struct {
+	Connection    *RelpConnection.RelpConnection
+	MaxBatchSize  int
+	MaxBatchBytes int
+	FlushInterval time.Duration
+	OnError       func(err error)
+	Logger        *slog.Logger
+	batch         *RelpBatch.RelpBatch
+	batchSize     int
+	batchBytes    int
+	mutex         sync.Mutex
+	stop          chan struct{}
+	stopped       chan struct{}
+}
+
+// Init initializes the batcher with the default values for the unset limits, and starts flushing
+// the batch periodically in the background. Close stops the batcher.
+func (batcher *RelpBatcher) Init() {
+	if batcher.MaxBatchSize <= 0 {
+		batcher.MaxBatchSize = 100
+	}
+	if batcher.MaxBatchBytes <= 0 {
+		batcher.MaxBatchBytes = 1024 * 1024
+	}
+	if batcher.FlushInterval <= 0 {
+		batcher.FlushInterval = time.Second
+	}
+	batcher.newBatch()
+	batcher.stop = make(chan struct{})
+	batcher.stopped = make(chan struct{})
+	go batcher.flushPeriodically()
+}
+
+// Add adds the syslog message to the batch, and commits the batch if it is full.
+// Returns the error of the commit, if one was made.
+func (batcher *RelpBatcher) Add(syslogMsg []byte) error {
+	batcher.mutex.Lock()
+	defer batcher.mutex.Unlock()
+	batcher.batch.Insert(syslogMsg)
+	batcher.batchSize++
+	batcher.batchBytes += len(syslogMsg)
+	if batcher.batchSize >= batcher.MaxBatchSize || batcher.batchBytes >= batcher.MaxBatchBytes {
+		return batcher.flush()
+	}
+	return nil
+}
+
+// Flush commits the messages added since the last successful commit
+func (batcher *RelpBatcher) Flush() error {
+	batcher.mutex.Lock()
+	defer batcher.mutex.Unlock()
+	return batcher.flush()
+}
+
+// Close stops the periodic flushing and commits the remaining messages
+func (batcher *RelpBatcher) Close() error {
+	close(batcher.stop)
+	<-batcher.stopped
+	return batcher.Flush()
+}
+
+// flush commits the batch. A batch whose every request got a response is replaced with a new one,
+// otherwise the unacknowledged requests are kept to be sent again on the next flush.
+func (batcher *RelpBatcher) flush() error {
+	if batcher.batch.GetWorkQueueLen() == 0 {
+		return nil
+	}
+
+	err := batcher.Connection.Commit(batcher.batch)
+	if err != nil {
+		batcher.batch.RetryAllUnacknowledged()
+		return err
+	}
+
+	for id := uint64(1); id <= batcher.batch.RequestId; id++ {
+		if !batcher.batch.VerifyTransaction(id) {
+			RelpLog.OrDefault(batcher.Logger).Warn("RelpBatcher> Message was rejected by the server", "reqId", id)
+		}
+	}
+	batcher.newBatch()
+	return nil
+}
+
+// newBatch replaces the batch with an empty one
+func (batcher *RelpBatcher) newBatch() {
+	batcher.batch = &RelpBatch.RelpBatch{Logger: batcher.Logger}
+	batcher.batch.Init()
+	batcher.batchSize = 0
+	batcher.batchBytes = 0
+}
+
+// flushPeriodically flushes the batch every FlushInterval until stopped.
+// Errors are passed to OnError, or logged if it has not been set.
+func (batcher *RelpBatcher) flushPeriodically() {
+	defer close(batcher.stopped)
+	ticker := time.NewTicker(batcher.FlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			err := batcher.Flush()
+			if err != nil {
+				if batcher.OnError != nil {
+					batcher.OnError(err)
+				} else {
+					RelpLog.OrDefault(batcher.Logger).Warn("RelpBatcher> Error flushing batch", "error", err)
+				}
+			}
+		case <-batcher.stop:
+			return
+		}
+	}
+}
@@ -0,0 +1,402 @@
+package RelpAdapter
+
+import (
+	"context"
+	"github.com/teragrep/rlp_05/internal/RelpLog"
+	"github.com/teragrep/rlp_05/pkg/Errors"
+	"github.com/teragrep/rlp_05/pkg/RelpBatch"
+	"github.com/teragrep/rlp_05/pkg/RelpConnection"
+	"github.com/teragrep/rlp_05/pkg/RelpSpool"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// constants for the overflow policies of RelpProducer (OVERFLOW_ prefix)
+const (
+	OVERFLOW_BLOCK       = 0
+	OVERFLOW_DROP_NEWEST = 1
+	OVERFLOW_DROP_OLDEST = 2
+	OVERFLOW_SPILL       = 3
+)
+
+// ProducerStats contains the message counters of a RelpProducer. Queued is the amount of messages waiting
+// in memory, Sent the amount answered by the server, of which Rejected were not accepted with 200 OK.
+// Dropped messages were discarded by the overflow policy or when closing, Spilled ones were written to the Spool.
+type ProducerStats struct {
+	Queued   int
+	Sent     uint64
+	Rejected uint64
+	Dropped  uint64
+	Spilled  uint64
+}
+
+// queuedMessage is a message waiting in the queue of a RelpProducer
+type queuedMessage struct {
+	payload  []byte
+	queuedAt time.Time
+}
+
+// RelpProducer accepts syslog messages into a bounded in-memory queue, and commits them over the Connection
+// in the background. A batch is committed once the queue has MaxBatchSize messages or MaxBatchBytes of payload,
+// or Linger has passed since the oldest message in the queue was queued. A failed commit is retried every RetryInterval
+// while the queue keeps filling up, the Connection's ReconnectPolicy should be set to recover lost connections.
+// Once QueueSize messages are queued, the Overflow policy decides whether Send blocks, drops the new message,
+// drops the oldest queued message, or spills the new messages to the Spool until the queue has been drained.
+// Spilled messages are sent in the order they were spilled, after the queued ones.
+type RelpProducer struct {
+	Connection    *RelpConnection.RelpConnection
+	QueueSize     int
+	MaxBatchSize  int
+	MaxBatchBytes int
+	Linger        time.Duration
+	RetryInterval time.Duration
+	Overflow      int
+	Spool         *RelpSpool.RelpSpool
+	OnError       func(err error)
+	Logger        *slog.Logger
+	queue         []queuedMessage
+	queueBytes    int
+	stats         ProducerStats
+	spilling      bool
+	closing       bool
+	mutex         sync.Mutex
+	wake          chan struct{}
+	space         chan struct{}
+	ctx           context.Context
+	cancel        context.CancelFunc
+	stopped       chan struct{}
+}
+
+// Init initializes the producer with the default values for the unset limits, and starts committing
+// in the background. Messages left in the Spool by a previous run are sent first, and new messages are
+// spilled after them until the Spool has been drained. Close stops the producer.
+func (producer *RelpProducer) Init() error {
+	if producer.Overflow < OVERFLOW_BLOCK || producer.Overflow > OVERFLOW_SPILL {
+		return &Errors.ProducerConfigError{Reason: "unknown overflow policy"}
+	}
+	if producer.Overflow == OVERFLOW_SPILL && producer.Spool == nil {
+		return &Errors.ProducerConfigError{Reason: "the Spool must be set to spill messages"}
+	}
+	if producer.QueueSize <= 0 {
+		producer.QueueSize = 10000
+	}
+	if producer.MaxBatchSize <= 0 {
+		producer.MaxBatchSize = 100
+	}
+	if producer.MaxBatchBytes <= 0 {
+		producer.MaxBatchBytes = 1024 * 1024
+	}
+	if producer.Linger <= 0 {
+		producer.Linger = 100 * time.Millisecond
+	}
+	if producer.RetryInterval <= 0 {
+		producer.RetryInterval = time.Second
+	}
+	producer.queue = nil
+	producer.queueBytes = 0
+	producer.stats = ProducerStats{}
+	producer.spilling = producer.Spool != nil
+	producer.closing = false
+	producer.wake = make(chan struct{}, 1)
+	producer.space = make(chan struct{})
+	producer.ctx, producer.cancel = context.WithCancel(context.Background())
+	producer.stopped = make(chan struct{})
+	go producer.run()
+	return nil
+}
+
+// Send queues the syslog message to be committed in the background. Only blocks with OVERFLOW_BLOCK while
+// the queue is full. Returns MessageDroppedError if the message was dropped by OVERFLOW_DROP_NEWEST,
+// or because the producer is closed.
+func (producer *RelpProducer) Send(syslogMsg []byte) error {
+	return producer.SendContext(context.Background(), syslogMsg)
+}
+
+// SendContext works like Send, but stops waiting for room in the queue once the context is done,
+// returning ctx.Err() without queueing the message.
+func (producer *RelpProducer) SendContext(ctx context.Context, syslogMsg []byte) error {
+	producer.mutex.Lock()
+	defer producer.mutex.Unlock()
+	for {
+		if producer.closing {
+			return &Errors.MessageDroppedError{Reason: "producer is closed"}
+		}
+		if producer.spilling {
+			// the queue is drained before the spilled messages, so the new ones are spilled after them
+			return producer.spill(syslogMsg)
+		}
+		if len(producer.queue) < producer.QueueSize {
+			producer.enqueue(syslogMsg)
+			return nil
+		}
+
+		switch producer.Overflow {
+		case OVERFLOW_DROP_NEWEST:
+			producer.stats.Dropped++
+			return &Errors.MessageDroppedError{Reason: "queue is full"}
+		case OVERFLOW_DROP_OLDEST:
+			producer.queueBytes -= len(producer.queue[0].payload)
+			producer.queue[0] = queuedMessage{}
+			producer.queue = producer.queue[1:]
+			producer.stats.Dropped++
+			producer.enqueue(syslogMsg)
+			return nil
+		case OVERFLOW_SPILL:
+			producer.spilling = true
+			return producer.spill(syslogMsg)
+		}
+
+		// OVERFLOW_BLOCK waits until a batch has been taken from the queue
+		space := producer.space
+		producer.mutex.Unlock()
+		select {
+		case <-space:
+			producer.mutex.Lock()
+		case <-ctx.Done():
+			producer.mutex.Lock()
+			return ctx.Err()
+		}
+	}
+}
+
+// Stats returns the current message counters
+func (producer *RelpProducer) Stats() ProducerStats {
+	producer.mutex.Lock()
+	defer producer.mutex.Unlock()
+	stats := producer.stats
+	stats.Queued = len(producer.queue)
+	return stats
+}
+
+// Close stops accepting messages, and waits until the queued and spilled messages have been committed
+func (producer *RelpProducer) Close() error {
+	return producer.CloseContext(context.Background())
+}
+
+// CloseContext works like Close, but stops committing once the context is done. The messages left unsent
+// are written to the Spool if it has been set, and dropped otherwise. Returns ctx.Err() in that case.
+func (producer *RelpProducer) CloseContext(ctx context.Context) error {
+	producer.mutex.Lock()
+	producer.closing = true
+	producer.freeSpace()
+	producer.mutex.Unlock()
+	producer.signal()
+
+	select {
+	case <-producer.stopped:
+		producer.cancel()
+		return nil
+	case <-ctx.Done():
+	}
+
+	producer.cancel()
+	<-producer.stopped
+	producer.mutex.Lock()
+	remaining := producer.takeBatch(len(producer.queue))
+	producer.mutex.Unlock()
+	producer.settle(remaining)
+	return ctx.Err()
+}
+
+// run commits the batches taken from the queue and the spool until the producer is closed
+func (producer *RelpProducer) run() {
+	defer close(producer.stopped)
+	for {
+		batch, ok := producer.nextBatch()
+		if !ok {
+			return
+		}
+		producer.commit(batch)
+	}
+}
+
+// nextBatch waits until a batch is ready to be committed, and takes it from the queue or the spool.
+// The boolean return value is false once the producer has been closed and everything has been committed,
+// or if the closing was canceled.
+func (producer *RelpProducer) nextBatch() (*RelpBatch.RelpBatch, bool) {
+	for {
+		var timeout <-chan time.Time
+		producer.mutex.Lock()
+		if producer.ctx.Err() != nil {
+			producer.mutex.Unlock()
+			return nil, false
+		}
+		if len(producer.queue) > 0 {
+			wait := producer.Linger - time.Since(producer.queue[0].queuedAt)
+			if producer.closing || wait <= 0 || len(producer.queue) >= producer.MaxBatchSize ||
+				producer.queueBytes >= producer.MaxBatchBytes {
+				batch := producer.takeBatch(producer.MaxBatchSize)
+				producer.mutex.Unlock()
+				return batch, true
+			}
+			timeout = time.After(wait)
+		} else if producer.spilling {
+			batch, err := producer.replaySpilled()
+			if batch != nil {
+				producer.mutex.Unlock()
+				return batch, true
+			}
+			if err == nil {
+				// the spool has been drained, the queue and closing are checked again without waiting
+				producer.mutex.Unlock()
+				continue
+			}
+			producer.mutex.Unlock()
+			producer.report(err)
+			timeout = time.After(producer.RetryInterval)
+			producer.mutex.Lock()
+		} else if producer.closing {
+			producer.mutex.Unlock()
+			return nil, false
+		}
+		producer.mutex.Unlock()
+
+		select {
+		case <-producer.wake:
+		case <-timeout:
+		case <-producer.ctx.Done():
+		}
+	}
+}
+
+// commit commits the batch, retrying the unacknowledged requests every RetryInterval until it succeeds
+// or the closing is canceled
+func (producer *RelpProducer) commit(batch *RelpBatch.RelpBatch) {
+	for {
+		err := producer.Connection.CommitContext(producer.ctx, batch)
+		if err == nil || producer.ctx.Err() != nil {
+			producer.settle(batch)
+			return
+		}
+		producer.report(err)
+		batch.RetryAllUnacknowledged()
+
+		timer := time.NewTimer(producer.RetryInterval)
+		select {
+		case <-timer.C:
+		case <-producer.ctx.Done():
+			timer.Stop()
+			producer.settle(batch)
+			return
+		}
+	}
+}
+
+// settle counts the answered requests of the batch as sent, and acknowledges the spilled ones in the spool.
+// Unanswered requests which were not spilled yet are spilled if the Spool has been set, and dropped otherwise.
+func (producer *RelpProducer) settle(batch *RelpBatch.RelpBatch) {
+	var acknowledged []uint64
+	var unsent [][]byte
+	producer.mutex.Lock()
+	for id := uint64(1); id <= batch.RequestId; id++ {
+		seq, isSpooled := batch.GetSpoolId(id)
+		if _, err := batch.GetResponse(id); err == nil {
+			producer.stats.Sent++
+			if !batch.VerifyTransaction(id) {
+				producer.stats.Rejected++
+				RelpLog.OrDefault(producer.Logger).Warn("RelpProducer> Message was rejected by the server", "reqId", id)
+			}
+			if isSpooled {
+				acknowledged = append(acknowledged, seq)
+			}
+		} else if !isSpooled {
+			request, _ := batch.GetRequest(id)
+			unsent = append(unsent, request.Data)
+		}
+	}
+
+	if len(unsent) > 0 && producer.Spool != nil {
+		if _, err := producer.Spool.Append(unsent...); err == nil {
+			producer.stats.Spilled += uint64(len(unsent))
+			unsent = nil
+		}
+	}
+	producer.stats.Dropped += uint64(len(unsent))
+	producer.mutex.Unlock()
+
+	if len(acknowledged) > 0 {
+		if err := producer.Spool.Acknowledge(acknowledged...); err != nil {
+			producer.report(err)
+		}
+	}
+}
+
+// enqueue appends the message to the queue and wakes up the committing goroutine, the mutex must be held
+func (producer *RelpProducer) enqueue(syslogMsg []byte) {
+	producer.queue = append(producer.queue, queuedMessage{payload: syslogMsg, queuedAt: time.Now()})
+	producer.queueBytes += len(syslogMsg)
+	producer.signal()
+}
+
+// spill appends the message to the spool, the mutex must be held
+func (producer *RelpProducer) spill(syslogMsg []byte) error {
+	_, err := producer.Spool.Append(syslogMsg)
+	if err != nil {
+		producer.stats.Dropped++
+		return err
+	}
+	producer.stats.Spilled++
+	producer.signal()
+	return nil
+}
+
+// takeBatch takes up to limit messages from the front of the queue into a new batch, keeping within
+// MaxBatchBytes unless the first message alone exceeds it. The mutex must be held.
+func (producer *RelpProducer) takeBatch(limit int) *RelpBatch.RelpBatch {
+	batch := &RelpBatch.RelpBatch{Logger: producer.Logger}
+	batch.Init()
+	count, size := 0, 0
+	for count < len(producer.queue) && count < limit {
+		payload := producer.queue[count].payload
+		if count > 0 && size+len(payload) > producer.MaxBatchBytes {
+			break
+		}
+		batch.Insert(payload)
+		size += len(payload)
+		producer.queue[count] = queuedMessage{}
+		count++
+	}
+	producer.queue = producer.queue[count:]
+	producer.queueBytes -= size
+	producer.freeSpace()
+	return batch
+}
+
+// replaySpilled replays up to MaxBatchSize spilled messages into a new batch. Returns nil once the spool has
+// been drained, after which new messages are queued again. The mutex must be held.
+func (producer *RelpProducer) replaySpilled() (*RelpBatch.RelpBatch, error) {
+	batch := &RelpBatch.RelpBatch{Logger: producer.Logger}
+	batch.Init()
+	replayed, err := producer.Spool.ReplayLimit(batch, producer.MaxBatchSize)
+	if replayed > 0 {
+		return batch, nil
+	}
+	if err == nil {
+		producer.spilling = false
+	}
+	return nil, err
+}
+
+// freeSpace wakes up the senders waiting for room in the queue, the mutex must be held
+func (producer *RelpProducer) freeSpace() {
+	close(producer.space)
+	producer.space = make(chan struct{})
+}
+
+// signal wakes up the committing goroutine
+func (producer *RelpProducer) signal() {
+	select {
+	case producer.wake <- struct{}{}:
+	default:
+	}
+}
+
+// report passes the error to OnError, or logs it if OnError has not been set
+func (producer *RelpProducer) report(err error) {
+	if producer.OnError != nil {
+		producer.OnError(err)
+	} else {
+		RelpLog.OrDefault(producer.Logger).Warn("RelpProducer> Error committing batch", "error", err)
+	}
+}
@@ -0,0 +1,134 @@
+package RelpAdapter
+
+import (
+	"context"
+	"github.com/teragrep/rlp_05/pkg/RelpSyslog"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// RelpSlogHandler is a slog.Handler formatting the records as syslog messages with the Formatter of the
+// Batcher's connection, and sending them using the Batcher. The record's attributes are appended to the message
+// as key=value pairs.
+// The handler must not be used by the Logger of the Batcher's connection, as logging during a commit would
+// wait for the commit itself to finish.
+type RelpSlogHandler struct {
+	Batcher  *RelpBatcher
+	Level    slog.Leveler
+	Facility int
+	Hostname string
+	AppName  string
+	attrs    string
+	group    string
+}
+
+// Init initializes the handler with the hostname of the machine and the name of the executable, if unset
+func (handler *RelpSlogHandler) Init() {
+	if handler.Level == nil {
+		handler.Level = slog.LevelInfo
+	}
+	if handler.Facility == 0 {
+		handler.Facility = RelpSyslog.FACILITY_USER
+	}
+	if handler.Hostname == "" {
+		hostname, err := os.Hostname()
+		if err == nil {
+			handler.Hostname = hostname
+		}
+	}
+	if handler.AppName == "" && len(os.Args) > 0 {
+		handler.AppName = os.Args[0][strings.LastIndex(os.Args[0], "/")+1:]
+	}
+}
+
+// Enabled reports whether the level is at least the handler's Level
+func (handler *RelpSlogHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= handler.Level.Level()
+}
+
+// Handle formats the record as a syslog message and adds it to the batcher
+func (handler *RelpSlogHandler) Handle(_ context.Context, record slog.Record) error {
+	msg := strings.Builder{}
+	msg.WriteString(record.Message)
+	msg.WriteString(handler.attrs)
+	record.Attrs(func(attr slog.Attr) bool {
+		appendAttr(&msg, handler.group, attr)
+		return true
+	})
+
+	syslogMsg := RelpSyslog.Message{
+		Facility:  handler.Facility,
+		Severity:  severity(record.Level),
+		Timestamp: record.Time,
+		Hostname:  handler.Hostname,
+		AppName:   handler.AppName,
+		ProcId:    strconv.Itoa(os.Getpid()),
+		Msg:       msg.String(),
+	}
+	formatted, err := handler.Batcher.Connection.FormatMessage(&syslogMsg)
+	if err != nil {
+		return err
+	}
+	return handler.Batcher.Add(formatted)
+}
+
+// WithAttrs returns a handler which adds the attributes to every record
+func (handler *RelpSlogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	clone := *handler
+	msg := strings.Builder{}
+	msg.WriteString(handler.attrs)
+	for _, attr := range attrs {
+		appendAttr(&msg, handler.group, attr)
+	}
+	clone.attrs = msg.String()
+	return &clone
+}
+
+// WithGroup returns a handler which prefixes the keys of the following attributes with the group name
+func (handler *RelpSlogHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return handler
+	}
+	clone := *handler
+	clone.group = handler.group + name + "."
+	return &clone
+}
+
+// appendAttr writes the attribute as " key=value", resolving groups into dotted keys
+func appendAttr(msg *strings.Builder, prefix string, attr slog.Attr) {
+	attr.Value = attr.Value.Resolve()
+	if attr.Equal(slog.Attr{}) {
+		return
+	}
+	if attr.Value.Kind() == slog.KindGroup {
+		groupPrefix := prefix
+		if attr.Key != "" {
+			groupPrefix = prefix + attr.Key + "."
+		}
+		for _, groupAttr := range attr.Value.Group() {
+			appendAttr(msg, groupPrefix, groupAttr)
+		}
+		return
+	}
+	msg.WriteString(" ")
+	msg.WriteString(prefix)
+	msg.WriteString(attr.Key)
+	msg.WriteString("=")
+	msg.WriteString(attr.Value.String())
+}
+
+// severity maps the slog level to a syslog severity
+func severity(level slog.Level) int {
+	switch {
+	case level >= slog.LevelError:
+		return RelpSyslog.SEVERITY_ERROR
+	case level >= slog.LevelWarn:
+		return RelpSyslog.SEVERITY_WARNING
+	case level >= slog.LevelInfo:
+		return RelpSyslog.SEVERITY_INFO
+	default:
+		return RelpSyslog.SEVERITY_DEBUG
+	}
+}
@@ -0,0 +1,61 @@
+package RelpAdapter
+
+import (
+	"bytes"
+	"sync"
+)
+
+// RelpWriter is an io.Writer sending the written data as syslog messages using the Batcher.
+// By default each Write becomes a single message. With SplitLines, each newline-delimited line becomes
+// a message, and an incomplete last line is kept until it is completed or the writer is closed.
+// A trailing newline is never part of the message.
+type RelpWriter struct {
+	Batcher    *RelpBatcher
+	SplitLines bool
+	remainder  []byte
+	mutex      sync.Mutex
+}
+
+// Write adds the data as syslog message(s) to the batcher. Returns the error of a commit, if one was made.
+func (writer *RelpWriter) Write(p []byte) (int, error) {
+	writer.mutex.Lock()
+	defer writer.mutex.Unlock()
+	if !writer.SplitLines {
+		msg := bytes.TrimSuffix(p, []byte{'\n'})
+		return len(p), writer.Batcher.Add(append([]byte(nil), msg...))
+	}
+
+	data := append(writer.remainder, p...)
+	var err error
+	for {
+		i := bytes.IndexByte(data, '\n')
+		if i < 0 {
+			break
+		}
+		if i > 0 {
+			addErr := writer.Batcher.Add(append([]byte(nil), data[:i]...))
+			if addErr != nil {
+				err = addErr
+			}
+		}
+		data = data[i+1:]
+	}
+	writer.remainder = append([]byte(nil), data...)
+	return len(p), err
+}
+
+// Close adds the incomplete last line as a message, and closes the batcher
+func (writer *RelpWriter) Close() error {
+	writer.mutex.Lock()
+	var addErr error
+	if len(writer.remainder) > 0 {
+		addErr = writer.Batcher.Add(writer.remainder)
+		writer.remainder = nil
+	}
+	writer.mutex.Unlock()
+	err := writer.Batcher.Close()
+	if err == nil {
+		err = addErr
+	}
+	return err
+}
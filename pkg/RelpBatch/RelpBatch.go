@@ -6,23 +6,41 @@ import (
 	"fmt"
 	"github.com/teragrep/rlp_05/internal/RelpCommand"
 	"github.com/teragrep/rlp_05/internal/RelpFrame"
-	"log"
+	"github.com/teragrep/rlp_05/internal/RelpLog"
+	"github.com/teragrep/rlp_05/pkg/Errors"
+	"log/slog"
+	"sync"
 )
 
 // RelpBatch struct contains all the request frames and their response counterparts.
 // the workQueue is used to keep track of the current, yet-to-be processed requests.
+// Responses may be put to the batch by the connection's reader goroutine while the batch is being sent.
+// The Logger is used for tracing the verification, and is set to the connection's logger on commit if left nil.
+// OnResponse is called with the request id and the response each time a response is put to the batch,
+// from the connection's reader goroutine, so it must not block.
 type RelpBatch struct {
-	requests  map[uint64]*RelpFrame.TX
-	responses map[uint64]*RelpFrame.RX
-	workQueue *list.List
-	RequestId uint64
+	Logger     *slog.Logger
+	OnResponse func(id uint64, response *RelpFrame.RX)
+	requests   map[uint64]*RelpFrame.TX
+	responses  map[uint64]*RelpFrame.RX
+	failures   map[uint64]error
+	workQueue  *list.List
+	queued     map[uint64]struct{}
+	spoolIds   map[uint64]uint64
+	RequestId  uint64
+	mutex      sync.Mutex
 }
 
 // Init initializes the batch with new maps and list
 func (batch *RelpBatch) Init() {
+	batch.mutex.Lock()
+	defer batch.mutex.Unlock()
 	batch.requests = make(map[uint64]*RelpFrame.TX)
 	batch.responses = make(map[uint64]*RelpFrame.RX)
+	batch.failures = make(map[uint64]error)
 	batch.workQueue = list.New()
+	batch.queued = make(map[uint64]struct{})
+	batch.spoolIds = make(map[uint64]uint64)
 	batch.RequestId = 0 // id within this batch
 }
 
@@ -45,9 +63,12 @@ func (batch *RelpBatch) Insert(syslogMsg []byte) uint64 {
 // batch.requestId is different from tx.transactionId
 // !!! requestId resets each batch but transactionId is the same for all for one relp session
 func (batch *RelpBatch) PutRequest(tx *RelpFrame.TX) uint64 {
+	batch.mutex.Lock()
+	defer batch.mutex.Unlock()
 	batch.RequestId += 1
 	batch.requests[batch.RequestId] = tx
 	batch.workQueue.PushBack(batch.RequestId)
+	batch.queued[batch.RequestId] = struct{}{}
 
 	return batch.RequestId
 }
@@ -55,6 +76,8 @@ func (batch *RelpBatch) PutRequest(tx *RelpFrame.TX) uint64 {
 // GetRequest gets the request frame from the requests map, if found.
 // Otherwise will send a "could not find batch <id> request" error
 func (batch *RelpBatch) GetRequest(id uint64) (*RelpFrame.TX, error) {
+	batch.mutex.Lock()
+	defer batch.mutex.Unlock()
 	v, ok := batch.requests[id]
 	if ok {
 		return v, nil
@@ -63,10 +86,24 @@ func (batch *RelpBatch) GetRequest(id uint64) (*RelpFrame.TX, error) {
 	}
 }
 
+// PutTransactionId sets the txnId the request is sent with, so that it can be read with Result while sending
+func (batch *RelpBatch) PutTransactionId(id uint64, txnId uint64) {
+	batch.mutex.Lock()
+	defer batch.mutex.Unlock()
+	if request, ok := batch.requests[id]; ok {
+		request.TransactionId = txnId
+	}
+}
+
 // RemoveRequest removes the specified request from the map and work queue
 func (batch *RelpBatch) RemoveRequest(id uint64) {
+	batch.mutex.Lock()
+	defer batch.mutex.Unlock()
 	// remove from requests map
 	delete(batch.requests, id)
+	delete(batch.failures, id)
+	delete(batch.queued, id)
+	delete(batch.spoolIds, id)
 
 	// find element to remove, and remove it using List.Remove
 	elem := batch.workQueue.Front()
@@ -82,6 +119,8 @@ func (batch *RelpBatch) RemoveRequest(id uint64) {
 // GetResponse gets the specified request from the map, if found
 // Otherwise, returns "could not find batch <id> response" error
 func (batch *RelpBatch) GetResponse(id uint64) (*RelpFrame.RX, error) {
+	batch.mutex.Lock()
+	defer batch.mutex.Unlock()
 	v, ok := batch.responses[id]
 	if ok {
 		return v, nil
@@ -92,44 +131,104 @@ func (batch *RelpBatch) GetResponse(id uint64) (*RelpFrame.RX, error) {
 
 // PutResponse puts the specified response frame to the response map
 func (batch *RelpBatch) PutResponse(id uint64, response *RelpFrame.RX) {
+	batch.mutex.Lock()
 	_, ok := batch.requests[id]
 	if ok {
 		batch.responses[id] = response
 	}
+	batch.mutex.Unlock()
+
+	// called without holding the mutex, so that the callback may use the batch
+	if ok && batch.OnResponse != nil {
+		batch.OnResponse(id, response)
+	}
+}
+
+// PutFailure saves the error which caused the request to be left without a response,
+// e.g. the server closing the connection while the request was pending
+func (batch *RelpBatch) PutFailure(id uint64, err error) {
+	batch.mutex.Lock()
+	defer batch.mutex.Unlock()
+	_, ok := batch.requests[id]
+	if ok {
+		batch.failures[id] = err
+	}
+}
+
+// GetFailure gets the error saved for the request with PutFailure, or nil if the request has not failed.
+// A response which could not be parsed during VerifyTransaction is saved as ProtocolError.
+func (batch *RelpBatch) GetFailure(id uint64) error {
+	batch.mutex.Lock()
+	defer batch.mutex.Unlock()
+	return batch.failures[id]
+}
+
+// PutSpoolId saves the sequence number the request was given in a RelpSpool
+func (batch *RelpBatch) PutSpoolId(id uint64, seq uint64) {
+	batch.mutex.Lock()
+	defer batch.mutex.Unlock()
+	_, ok := batch.requests[id]
+	if ok {
+		batch.spoolIds[id] = seq
+	}
+}
+
+// GetSpoolId gets the RelpSpool sequence number of the request. The boolean return value is false
+// if the request has not been spooled.
+func (batch *RelpBatch) GetSpoolId(id uint64) (uint64, bool) {
+	batch.mutex.Lock()
+	defer batch.mutex.Unlock()
+	seq, ok := batch.spoolIds[id]
+	return seq, ok
 }
 
 // VerifyTransaction verifies, that the id given has a matching request and response frame saved,
 // and that the response code is 200 OK
 func (batch *RelpBatch) VerifyTransaction(id uint64) bool {
-	log.Printf("Verifying transaction (batch-specific id, NOT txnId): %v\n", id)
+	batch.mutex.Lock()
+	defer batch.mutex.Unlock()
+	return batch.verifyTransaction(id)
+}
+
+// verifyTransaction is VerifyTransaction for callers already holding the batch mutex
+func (batch *RelpBatch) verifyTransaction(id uint64) bool {
+	logger := RelpLog.OrDefault(batch.Logger)
+	logger.Debug("Verifying transaction (batch-specific id, NOT txnId)", "reqId", id)
 	req, hasRequest := batch.requests[id]
 	if hasRequest {
-		log.Printf("Verify: Got request: %v %v %v %v\n", req.TransactionId, req.Cmd, req.DataLength, string(req.Data))
+		logger.Debug("Verify: Got request", "txnId", req.TransactionId, "cmd", req.Cmd, "len", req.DataLength)
 		resp, hasResponse := batch.responses[id]
 		if hasResponse {
-			log.Printf("Verify: Got response: %v %v %v %v\n", resp.TransactionId, resp.Cmd, resp.DataLength, string(resp.Data))
-			log.Printf("Transaction %v has a request and response\n", id)
+			logger.Debug("Verify: Got response", "txnId", resp.TransactionId, "cmd", resp.Cmd, "len", resp.DataLength)
 			num, err := resp.ParseResponseCode()
 			if err != nil {
-				panic(fmt.Sprintf("Could not parse response code for transaction %v", id))
+				// a malformed response can't verify the transaction, the reason is kept as the failure
+				logger.Warn("Could not parse response code for transaction", "reqId", id, "error", err)
+				batch.failures[id] = &Errors.ProtocolError{Err: err}
 			} else {
 				if num == 200 {
-					log.Printf("Transaction %v successfully verified.\n", id)
+					logger.Debug("Transaction successfully verified", "reqId", id)
 					return true
 				}
 			}
 		}
 	}
-	log.Printf("Transaction %v could not be verified successfully!\n", id)
+	logger.Debug("Transaction could not be verified successfully", "reqId", id)
 	return false
 }
 
-// VerifyTransactionAll goes through all requests and runs VerifyTransaction on all of them.
-// Returns false if any one of the transactions could not be verified, otherwise true.
+// VerifyTransactionAll goes through all requests in the order they were put to the batch, and runs
+// VerifyTransaction on them. Returns false if any one of the transactions could not be verified, otherwise true.
+// Results reports the status of every request instead.
 func (batch *RelpBatch) VerifyTransactionAll() bool {
-	log.Printf("Verifying ALL transactions\n")
-	for id := range batch.requests {
-		verified := batch.VerifyTransaction(id)
+	batch.mutex.Lock()
+	defer batch.mutex.Unlock()
+	RelpLog.OrDefault(batch.Logger).Debug("Verifying ALL transactions")
+	for id := uint64(1); id <= batch.RequestId; id++ {
+		if _, ok := batch.requests[id]; !ok {
+			continue
+		}
+		verified := batch.verifyTransaction(id)
 		if !verified {
 			return false
 		}
@@ -137,38 +236,120 @@ func (batch *RelpBatch) VerifyTransactionAll() bool {
 	return true
 }
 
+// Result returns the delivery result of the request. The boolean return value is false if the batch
+// has no request with the id.
+func (batch *RelpBatch) Result(id uint64) (Result, bool) {
+	batch.mutex.Lock()
+	defer batch.mutex.Unlock()
+	if _, ok := batch.requests[id]; !ok {
+		return Result{}, false
+	}
+	return batch.result(id), true
+}
+
+// Results returns the delivery result of every request, in the order they were put to the batch
+func (batch *RelpBatch) Results() []Result {
+	batch.mutex.Lock()
+	defer batch.mutex.Unlock()
+	results := make([]Result, 0, len(batch.requests))
+	for id := uint64(1); id <= batch.RequestId; id++ {
+		if _, ok := batch.requests[id]; ok {
+			results = append(results, batch.result(id))
+		}
+	}
+	return results
+}
+
+// result is Result for callers already holding the batch mutex and knowing the request exists
+func (batch *RelpBatch) result(id uint64) Result {
+	result := Result{RequestId: id, TransactionId: batch.requests[id].TransactionId, Status: RESULT_NO_RESPONSE}
+	if response, hasResponse := batch.responses[id]; hasResponse {
+		parsed, err := response.ParseResponse()
+		if err != nil {
+			result.Status = RESULT_FAILED
+			result.Err = &Errors.ProtocolError{Err: err}
+			return result
+		}
+		result.Code = parsed.Code
+		result.Text = parsed.Text
+		if parsed.Code == 200 {
+			result.Status = RESULT_ACKNOWLEDGED
+		} else {
+			result.Status = RESULT_REJECTED
+		}
+	} else if failure := batch.failures[id]; failure != nil {
+		result.Status = RESULT_FAILED
+		result.Err = failure
+	}
+	return result
+}
+
 // RetryRequest retries sending the relp request frame by pushing it back
-// to the work queue
+// to the work queue, unless it is still in the work queue
 func (batch *RelpBatch) RetryRequest(id uint64) {
-	log.Printf("Retrying: Pushing request %v back to work queue", id)
+	batch.mutex.Lock()
+	defer batch.mutex.Unlock()
+	batch.retryRequest(id)
+}
+
+// retryRequest is RetryRequest for callers already holding the batch mutex
+func (batch *RelpBatch) retryRequest(id uint64) {
+	RelpLog.OrDefault(batch.Logger).Debug("Retrying: Pushing request back to work queue", "reqId", id)
 	_, ok := batch.requests[id]
-	if ok {
+	_, isQueued := batch.queued[id]
+	if ok && !isQueued {
+		delete(batch.failures, id)
 		batch.workQueue.PushBack(id)
+		batch.queued[id] = struct{}{}
 	}
 }
 
 // RetryAllFailed verifies all transactions, and adds all the failed-to-verify requests back
-// to the work queue
+// to the work queue, in the order they were put to the batch
 func (batch *RelpBatch) RetryAllFailed() {
-	log.Printf("Verifying ALL transactions and retrying failed ones\n")
-	for id := range batch.requests {
-		verified := batch.VerifyTransaction(id)
+	batch.mutex.Lock()
+	defer batch.mutex.Unlock()
+	RelpLog.OrDefault(batch.Logger).Debug("Verifying ALL transactions and retrying failed ones")
+	for id := uint64(1); id <= batch.RequestId; id++ {
+		if _, ok := batch.requests[id]; !ok {
+			continue
+		}
+		verified := batch.verifyTransaction(id)
 		if !verified {
-			batch.RetryRequest(id)
+			batch.retryRequest(id)
+		}
+	}
+}
+
+// RetryAllUnacknowledged adds all the requests which did not get a response back to the work queue,
+// in the order they were put to the batch. Requests rejected by the server are not retried.
+func (batch *RelpBatch) RetryAllUnacknowledged() {
+	batch.mutex.Lock()
+	defer batch.mutex.Unlock()
+	RelpLog.OrDefault(batch.Logger).Debug("Retrying ALL unacknowledged transactions")
+	for id := uint64(1); id <= batch.RequestId; id++ {
+		_, hasResponse := batch.responses[id]
+		if !hasResponse {
+			batch.retryRequest(id)
 		}
 	}
 }
 
 // GetWorkQueueLen gets the amount of requests in the work queue
 func (batch *RelpBatch) GetWorkQueueLen() int {
+	batch.mutex.Lock()
+	defer batch.mutex.Unlock()
 	return batch.workQueue.Len()
 }
 
 // PopWorkQueue gets the front element from the work queue,
 // deletes it from the queue and returns the ID for that request frame
 func (batch *RelpBatch) PopWorkQueue() uint64 {
+	batch.mutex.Lock()
+	defer batch.mutex.Unlock()
 	elem := batch.workQueue.Front()
 	id := elem.Value.(uint64)
 	batch.workQueue.Remove(elem)
+	delete(batch.queued, id)
 	return id
 }
@@ -0,0 +1,23 @@
+package RelpBatch
+
+// constants for the delivery statuses of the requests (RESULT_ prefix)
+const (
+	RESULT_NO_RESPONSE  = 0
+	RESULT_ACKNOWLEDGED = 1
+	RESULT_REJECTED     = 2
+	RESULT_FAILED       = 3
+)
+
+// Result is the delivery status of a single request of the batch. RESULT_ACKNOWLEDGED requests were answered
+// with 200 OK, and RESULT_REJECTED ones with another Code and the server's Text. RESULT_FAILED requests were
+// left without a valid response because of the Err, e.g. the connection being lost, and RESULT_NO_RESPONSE
+// requests have not been answered yet, or were never sent. TransactionId is the RELP txnId the request was
+// last sent with, or 0 if it was never sent.
+type Result struct {
+	RequestId     uint64
+	TransactionId uint64
+	Status        int
+	Code          int
+	Text          string
+	Err           error
+}
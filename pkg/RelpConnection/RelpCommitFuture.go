@@ -0,0 +1,86 @@
+package RelpConnection
+
+import (
+	"context"
+	"github.com/teragrep/rlp_05/pkg/RelpBatch"
+)
+
+// CommitFuture is the handle of a batch committed in the background with CommitAsync.
+// Once the commit has finished, Results returns the delivery result of each request.
+type CommitFuture struct {
+	batch *RelpBatch.RelpBatch
+	done  chan struct{}
+	err   error
+}
+
+// Batch returns the batch being committed
+func (future *CommitFuture) Batch() *RelpBatch.RelpBatch {
+	return future.batch
+}
+
+// Done returns a channel which is closed once the commit has finished
+func (future *CommitFuture) Done() <-chan struct{} {
+	return future.done
+}
+
+// Err returns the error of the finished commit, or nil if the commit succeeded or has not finished yet
+func (future *CommitFuture) Err() error {
+	select {
+	case <-future.done:
+		return future.err
+	default:
+		return nil
+	}
+}
+
+// Results returns the delivery result of every request of the batch, once the commit has finished
+func (future *CommitFuture) Results() []RelpBatch.Result {
+	<-future.done
+	return future.batch.Results()
+}
+
+// Wait blocks until the commit has finished and returns its error
+func (future *CommitFuture) Wait() error {
+	<-future.done
+	return future.err
+}
+
+// WaitContext works like Wait, but returns ctx.Err() if the context is done first. The commit is not canceled.
+func (future *CommitFuture) WaitContext(ctx context.Context) error {
+	select {
+	case <-future.done:
+		return future.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// resolve saves the error of the commit and marks the future done
+func (future *CommitFuture) resolve(err error) {
+	future.err = err
+	close(future.done)
+}
+
+// CommitAsync commits the batch in the background, returning immediately with a handle to the commit.
+// The batch must not be modified before the commit has finished. Batches committed one after another
+// are sent concurrently, so their frames may be interleaved on the wire.
+func (relpConn *RelpConnection) CommitAsync(batch *RelpBatch.RelpBatch) *CommitFuture {
+	return relpConn.CommitAsyncContext(context.Background(), batch)
+}
+
+// CommitAsyncContext works like CommitAsync, committing the batch with CommitContext using the given context
+func (relpConn *RelpConnection) CommitAsyncContext(ctx context.Context, batch *RelpBatch.RelpBatch) *CommitFuture {
+	future := &CommitFuture{batch: batch, done: make(chan struct{})}
+	// counted as started before returning, so that disconnecting right after waits for the commit
+	reader, open := relpConn.beginCommit()
+	if !open {
+		future.resolve(relpConn.invalidStateError("commit", STATE_OPEN))
+		return future
+	}
+
+	go func() {
+		defer relpConn.endCommit()
+		future.resolve(relpConn.commitStarted(ctx, reader, batch))
+	}()
+	return future
+}
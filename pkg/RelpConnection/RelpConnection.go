@@ -2,18 +2,22 @@ package RelpConnection
 
 import (
 	"bytes"
+	"context"
 	"crypto/tls"
 	"errors"
-	"github.com/teragrep/rlp_05/internal/Errors"
+	"fmt"
 	"github.com/teragrep/rlp_05/internal/RelpCommand"
 	"github.com/teragrep/rlp_05/internal/RelpFrame"
-	"github.com/teragrep/rlp_05/internal/RelpParser"
+	"github.com/teragrep/rlp_05/internal/RelpLog"
 	"github.com/teragrep/rlp_05/internal/RelpWindow"
+	"github.com/teragrep/rlp_05/pkg/Errors"
 	"github.com/teragrep/rlp_05/pkg/RelpBatch"
 	"github.com/teragrep/rlp_05/pkg/RelpDialer"
-	"io"
-	"log"
-	"os"
+	"github.com/teragrep/rlp_05/pkg/RelpSpool"
+	"github.com/teragrep/rlp_05/pkg/RelpSyslog"
+	"log/slog"
+	"strings"
+	"sync"
 	"time"
 )
 
@@ -25,7 +29,9 @@ const (
 )
 
 // RelpConnection struct contains the necessary fields to
-// manage a TCP connection to the RELP server
+// manage a TCP connection to the RELP server.
+// Batches may be committed from multiple goroutines at the same time: the frames are written one at a time
+// under the send mutex, and each commit waits only for the ACKs of its own batch.
 type RelpConnection struct {
 	RelpDialer.RelpDialer
 	txId                 uint64
@@ -33,14 +39,28 @@ type RelpConnection struct {
 	txBufferSize         int
 	preAllocTxBuffer     *bytes.Buffer
 	preAllocRxBuffer     []byte
+	reader               *relpReader
 	state                int
 	Window               *RelpWindow.RelpWindow
+	MaxWindowSize        int
 	offer                []byte
 	lastIp               string
 	lastPort             int
 	ackTimeoutDuration   time.Duration
 	writeTimeoutDuration time.Duration
 	TlsConfig            *tls.Config
+	Logger               *slog.Logger
+	ReconnectPolicy      *ReconnectPolicy
+	Spool                *RelpSpool.RelpSpool
+	Formatter            RelpSyslog.Formatter
+	Software             string
+	Commands             []string
+	ServerOffer          *ServerOffer
+	commits              int
+	dialed               bool
+	mutex                sync.Mutex
+	sendMutex            sync.Mutex
+	reconnectMutex       sync.Mutex
 }
 
 // Init initializes the connection struct with CLOSED state and allocates the TX/RX buffers
@@ -51,246 +71,730 @@ func (relpConn *RelpConnection) Init() {
 	relpConn.preAllocRxBuffer = make([]byte, relpConn.rxBufferSize)
 	relpConn.preAllocTxBuffer = bytes.NewBuffer(make([]byte, 0, relpConn.txBufferSize))
 	relpConn.txId = 0 // sendBatch() increments this by one before sending
+	relpConn.reader = &relpReader{}
 	relpConn.Window = &RelpWindow.RelpWindow{}
-	relpConn.offer = []byte("\nrelp_version=0\nrelp_software=RLP-05\ncommands=syslog\n")
+	relpConn.MaxWindowSize = 128
+	relpConn.Software = "RLP-05"
 	relpConn.ackTimeoutDuration = 30 * time.Second
 	relpConn.writeTimeoutDuration = 30 * time.Second
 	relpConn.TlsConfig = &tls.Config{}
+	relpConn.Formatter = &RelpSyslog.Rfc5424Formatter{}
 }
 
 // Connect connects to the specified RELP server and sends OPEN message to initialize the connection.
 // The returned boolean value specifies if the connection could be verified or not
 func (relpConn *RelpConnection) Connect(hostname string, port int) (bool, error) {
+	return relpConn.ConnectContext(context.Background(), hostname, port)
+}
+
+// ConnectContext works like Connect, but aborts dialing and opening the session once the context is done.
+// The connection is torn down and ctx.Err() is returned in that case.
+func (relpConn *RelpConnection) ConnectContext(ctx context.Context, hostname string, port int) (bool, error) {
+	relpConn.mutex.Lock()
 	if relpConn.state != STATE_CLOSED {
-		panic("Can't connect, the connection is not closed")
+		relpConn.mutex.Unlock()
+		return false, relpConn.invalidStateError("connect", STATE_CLOSED)
 	}
 
 	if relpConn.RelpDialer == nil {
-		panic("Can't connect, RelpDialer has not been set! Please set as RelpTLSDialer or RelpPlainDialer." +
-			" Use the relpConnection.tlsConfig to configure the TLS connection!")
+		relpConn.mutex.Unlock()
+		return false, &Errors.DialerNotSetError{}
+	}
+
+	// a failed open leaves the previous session's reader running, stop it before reusing the window
+	if relpConn.reader.isRunning() {
+		relpConn.tearDown()
 	}
 
 	// save used IP and port in case of needing to reconnect
 	relpConn.lastIp = hostname
 	relpConn.lastPort = port
 
-	// reset txId & relpWindow
+	// requests left pending by the previous session are failed before resetting the txId & relpWindow.
+	// Commits still using the previous session stop sending once its reader has been replaced.
+	relpConn.sendMutex.Lock()
+	if relpConn.Window.Size() > 0 {
+		relpConn.failPending(relpConn.reader.failure())
+	}
 	relpConn.txId = 0
+	relpConn.ServerOffer = nil
 	relpConn.Window.Init()
+	relpConn.Window.Logger = relpConn.Logger
+	reader := &relpReader{}
+	relpConn.reader = reader
+	relpConn.sendMutex.Unlock()
+	relpConn.mutex.Unlock()
 
-	encrypted, netErr := relpConn.RelpDialer.Dial(hostname, port, relpConn.TlsConfig)
+	encrypted, netErr := relpConn.dial(ctx, hostname, port)
 	if netErr != nil {
-		return false, &Errors.ConnectionEstablishmentError{
+		if ctx.Err() != nil {
+			return false, ctx.Err()
+		}
+		var verificationErr *Errors.PeerVerificationError
+		if errors.As(netErr, &verificationErr) {
+			relpConn.logger().Warn("RELP server could not be verified", "hostname", hostname, "port", port,
+				"error", verificationErr)
+			return false, verificationErr
+		}
+		connErr := &Errors.ConnectionEstablishmentError{
 			Hostname:  hostname,
 			Port:      port,
 			Reason:    netErr.Error(),
 			Encrypted: encrypted,
 			Protocol:  "tcp",
+			Err:       netErr,
+		}
+		var dialErr *Errors.DialError
+		if errors.As(netErr, &dialErr) {
+			connErr.Address = dialErr.Address
+			connErr.Protocol = dialErr.Network
 		}
+		return false, connErr
 	}
 
-	// send open session message
+	// responses are read in the background for as long as the connection is up
+	relpConn.mutex.Lock()
+	reader.init(relpConn.RelpDialer, relpConn.Window, relpConn.preAllocRxBuffer, relpConn.logger())
+	reader.start()
+	relpConn.dialed = true
+	relpConn.mutex.Unlock()
+	go relpConn.closeOnServerClose(reader)
+
+	// send open session message, offering syslog and the additional Commands
+	commands := append([]string{RelpCommand.RELP_SYSLOG}, relpConn.Commands...)
+	relpConn.offer = []byte(fmt.Sprintf("\nrelp_version=%v\nrelp_software=%v\ncommands=%v\n",
+		RELP_VERSION, relpConn.Software, strings.Join(commands, ",")))
 	relpRequest := RelpFrame.TX{
 		Frame: RelpFrame.Frame{
-			TransactionId: relpConn.txId,
-			Cmd:           RelpCommand.RELP_OPEN,
-			DataLength:    len(relpConn.offer),
-			Data:          relpConn.offer,
+			Cmd:        RelpCommand.RELP_OPEN,
+			DataLength: len(relpConn.offer),
+			Data:       relpConn.offer,
 		},
 	}
-	openerBatch := RelpBatch.RelpBatch{}
+	openerBatch := RelpBatch.RelpBatch{Logger: relpConn.Logger}
 	openerBatch.Init()
 
 	reqId := openerBatch.PutRequest(&relpRequest)
-	err := relpConn.SendBatch(&openerBatch)
+	err := relpConn.sendBatch(ctx, reader, &openerBatch)
+	if err != nil && err == ctx.Err() {
+		relpConn.tearDownSession(reader)
+		return false, err
+	}
 	success := openerBatch.VerifyTransaction(reqId)
 	if success {
-		log.Println("[SUCCESS] Successfully opened connection to RELP server")
+		// the server's offer must be compatible before the session can be used
+		response, _ := openerBatch.GetResponse(reqId)
+		parsed, _ := response.ParseResponse()
+		offer, offerErr := parseServerOffer(parsed.Lines)
+		if offerErr != nil {
+			relpConn.logger().Warn("Server offer was not accepted", "hostname", hostname, "port", port,
+				"error", offerErr)
+			relpConn.tearDownSession(reader)
+			return false, offerErr
+		}
+		relpConn.ServerOffer = offer
+		relpConn.logger().Info("Successfully opened connection to RELP server", "hostname", hostname, "port", port)
+		relpConn.mutex.Lock()
 		relpConn.state = STATE_OPEN
+		relpConn.mutex.Unlock()
 	} else {
-		log.Println("[FAIL] Connection failed, initial transaction could not be verified")
+		relpConn.logger().Warn("Connection failed, initial transaction could not be verified",
+			"hostname", hostname, "port", port)
 	}
 
 	return success, err
 }
 
-// TearDown closes the connection to the server.
-// The Disconnect method should be used instead.
+// dial dials with DialContext if the RelpDialer implements RelpContextDialer, otherwise with Dial
+func (relpConn *RelpConnection) dial(ctx context.Context, hostname string, port int) (bool, error) {
+	if dialer, ok := relpConn.RelpDialer.(RelpDialer.RelpContextDialer); ok {
+		return dialer.DialContext(ctx, hostname, port, relpConn.TlsConfig)
+	}
+	return relpConn.RelpDialer.Dial(hostname, port, relpConn.TlsConfig)
+}
+
+// TearDown closes the connection to the server and waits for the reader goroutine to stop.
+// Commits in progress fail with the reader's error, and don't reconnect. The Disconnect method should be used instead.
 func (relpConn *RelpConnection) TearDown() {
-	err := relpConn.RelpDialer.Close()
-	if err != nil {
-		log.Println("Error closing RELP connection")
+	relpConn.mutex.Lock()
+	defer relpConn.mutex.Unlock()
+	relpConn.reader.closed = true
+	relpConn.tearDown()
+}
+
+// tearDown is TearDown for callers already holding the mutex. The dialer is closed only once per session.
+func (relpConn *RelpConnection) tearDown() {
+	if relpConn.dialed {
+		err := relpConn.RelpDialer.Close()
+		if err != nil {
+			relpConn.logger().Debug("Error closing RELP connection", "error", err)
+		}
+		relpConn.dialed = false
+	}
+	if relpConn.reader.isRunning() {
+		<-relpConn.reader.done
 	}
 
 	relpConn.state = STATE_CLOSED
 }
 
+// tearDownSession tears down the connection, unless the session of the reader has already been
+// replaced by connecting again
+func (relpConn *RelpConnection) tearDownSession(reader *relpReader) {
+	relpConn.mutex.Lock()
+	defer relpConn.mutex.Unlock()
+	if relpConn.reader == reader {
+		relpConn.tearDown()
+	}
+}
+
+// closeSession tears down the connection on purpose, so that the commits in progress don't reconnect,
+// unless the session of the reader has already been replaced by connecting again
+func (relpConn *RelpConnection) closeSession(reader *relpReader) {
+	relpConn.mutex.Lock()
+	defer relpConn.mutex.Unlock()
+	if relpConn.reader == reader {
+		reader.closed = true
+		relpConn.tearDown()
+	}
+}
+
+// closedOnPurpose returns true if the commit failed because of Abort, or the current session was closed
+// with TearDown, Abort or Disconnect
+func (relpConn *RelpConnection) closedOnPurpose(err error) bool {
+	var abortErr *Errors.AbortError
+	if errors.As(err, &abortErr) {
+		return true
+	}
+	relpConn.mutex.Lock()
+	defer relpConn.mutex.Unlock()
+	return relpConn.reader.closed
+}
+
 // Disconnect sends the CLOSE message to the server, and tries to disconnect gracefully.
-// Calls the TearDown method if the CLOSE message was acknowledged by the server
-func (relpConn *RelpConnection) Disconnect() bool {
-	if relpConn.state != STATE_OPEN {
-		panic("Cannot disconnect, connection was not OPEN")
+// Calls the TearDown method if the CLOSE message was acknowledged by the server,
+// or if the server had already closed the session with serverclose. Otherwise returns the error of sending
+// the CLOSE message or reading its ACK.
+func (relpConn *RelpConnection) Disconnect() (bool, error) {
+	return relpConn.DisconnectContext(context.Background())
+}
+
+// DisconnectContext works like Disconnect, but stops waiting for the server to acknowledge
+// the CLOSE message once the context is done. The connection is torn down and ctx.Err() is returned in that case.
+func (relpConn *RelpConnection) DisconnectContext(ctx context.Context) (bool, error) {
+	if relpConn.currentState() != STATE_OPEN {
+		return false, relpConn.invalidStateError("disconnect", STATE_OPEN)
 	}
+	reader, _ := relpConn.session()
 	relpRequest := RelpFrame.TX{Frame: RelpFrame.Frame{
-		TransactionId: relpConn.txId,
-		Cmd:           RelpCommand.RELP_CLOSE,
-		DataLength:    0,
-		Data:          nil,
+		Cmd:        RelpCommand.RELP_CLOSE,
+		DataLength: 0,
+		Data:       nil,
 	}}
 
-	closerBatch := RelpBatch.RelpBatch{}
+	closerBatch := RelpBatch.RelpBatch{Logger: relpConn.Logger}
 	closerBatch.Init()
 
 	reqId := closerBatch.PutRequest(&relpRequest)
-	err := relpConn.SendBatch(&closerBatch)
+	err := relpConn.sendBatch(ctx, reader, &closerBatch)
+	if err != nil && err == ctx.Err() {
+		relpConn.closeSession(reader)
+		return false, err
+	}
 	success := false
-	closeResp, err := closerBatch.GetResponse(reqId)
-	if err == nil && closeResp != nil && closeResp.DataLength == 0 {
+	var serverCloseErr *Errors.ServerCloseError
+	if errors.As(err, &serverCloseErr) {
+		// the server has already closed the session
+		success = true
+	}
+	closeResp, respErr := closerBatch.GetResponse(reqId)
+	if respErr == nil && closeResp != nil && closeResp.DataLength == 0 {
 		success = true
 	}
 
-	if success {
-		// if sending CLOSE command was successful, close connection and set state to CLOSED
-		relpConn.TearDown()
+	if !success {
+		if err == nil {
+			err = &Errors.AckReadingError{Reason: "close was not acknowledged"}
+		}
+		return false, err
 	}
 
-	return success
+	// if sending CLOSE command was successful, close connection and set state to CLOSED
+	relpConn.closeSession(reader)
+	return true, nil
 }
 
-// Commit commits the RELP batch to the server
+// Commit commits the RELP batch to the server. Batches may be committed from multiple goroutines
+// at the same time, and each commit returns once the requests of its own batch have been acknowledged.
+// If the server sends serverclose during the commit, the connection is torn down and
+// the returned error is ServerCloseError.
 func (relpConn *RelpConnection) Commit(batch *RelpBatch.RelpBatch) error {
-	if relpConn.state != STATE_OPEN {
-		panic("Can't commit, connection was in state other than OPEN.")
+	return relpConn.CommitContext(context.Background(), batch)
+}
+
+// CommitContext works like Commit, but stops sending and waiting for ACKs once the context is done.
+// The pending requests of the batch are failed and ctx.Err() is returned in that case. The connection
+// stays open for the other commits, unless the context interrupted writing a frame.
+// If the ReconnectPolicy has been set, a commit failing for other reasons is retried by reconnecting
+// and resending the unacknowledged requests of the batch, until the policy gives up. A commit failing
+// because the connection was aborted, disconnected or torn down is not retried.
+// If the Spool has been set, the syslog requests are written to it before sending, and removed from it
+// once verified.
+func (relpConn *RelpConnection) CommitContext(ctx context.Context, batch *RelpBatch.RelpBatch) error {
+	reader, open := relpConn.beginCommit()
+	if !open {
+		return relpConn.invalidStateError("commit", STATE_OPEN)
 	}
+	defer relpConn.endCommit()
+	return relpConn.commitStarted(ctx, reader, batch)
+}
 
-	relpConn.state = STATE_COMMIT
-	err := relpConn.SendBatch(batch)
-	relpConn.state = STATE_OPEN
+// commitStarted is CommitContext for a commit already counted as started with beginCommit
+func (relpConn *RelpConnection) commitStarted(ctx context.Context, reader *relpReader, batch *RelpBatch.RelpBatch) error {
+	if relpConn.Spool != nil {
+		spoolErr := relpConn.Spool.SpoolBatch(batch)
+		if spoolErr != nil {
+			return spoolErr
+		}
+	}
+
+	err := relpConn.commit(ctx, reader, batch)
+	if err != nil && relpConn.ReconnectPolicy != nil && ctx.Err() == nil {
+		err = relpConn.reconnectAndCommit(ctx, batch, reader, err)
+	}
+
+	if relpConn.Spool != nil {
+		ackErr := relpConn.Spool.AcknowledgeBatch(batch)
+		if err == nil {
+			err = ackErr
+		}
+	}
 	return err
 }
 
+// reconnectAndCommit reconnects to the last used hostname and port and commits the unacknowledged
+// requests of the batch again, following the ReconnectPolicy. Returns the last error if the policy gives up.
+func (relpConn *RelpConnection) reconnectAndCommit(ctx context.Context, batch *RelpBatch.RelpBatch, failed *relpReader, err error) error {
+	policy := relpConn.ReconnectPolicy
+	start := time.Now()
+	for attempt := 1; ; attempt++ {
+		if relpConn.closedOnPurpose(err) {
+			return err
+		}
+		reader, reconnectErr := relpConn.reconnect(ctx, failed, attempt, err)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if reconnectErr == nil {
+			batch.RetryAllUnacknowledged()
+			err = relpConn.commit(ctx, reader, batch)
+			if err == nil || ctx.Err() != nil {
+				return err
+			}
+			failed = reader
+		} else {
+			err = reconnectErr
+		}
+
+		if policy.isExhausted(attempt, start) {
+			relpConn.logger().Warn("Gave up reconnecting to RELP server", "attempts", attempt, "error", err)
+			policy.emit(EVENT_GAVE_UP, attempt, err)
+			return err
+		}
+	}
+}
+
+// reconnect replaces the failed session by connecting again after the policy's interval, and returns
+// the reader of the new session. Commits failing at the same time reconnect one at a time, and the
+// session is reused if another commit has already replaced the failed one.
+func (relpConn *RelpConnection) reconnect(ctx context.Context, failed *relpReader, attempt int, err error) (*relpReader, error) {
+	relpConn.reconnectMutex.Lock()
+	defer relpConn.reconnectMutex.Unlock()
+	if reader, open := relpConn.session(); open && reader != failed {
+		return reader, nil
+	}
+
+	policy := relpConn.ReconnectPolicy
+	relpConn.logger().Info("Reconnecting to RELP server", "attempt", attempt, "error", err)
+	policy.emit(EVENT_RECONNECTING, attempt, err)
+
+	timer := time.NewTimer(policy.interval(attempt))
+	select {
+	case <-timer.C:
+	case <-ctx.Done():
+		timer.Stop()
+		return nil, ctx.Err()
+	}
+
+	relpConn.mutex.Lock()
+	if relpConn.reader.closed {
+		// closed on purpose while waiting
+		relpConn.mutex.Unlock()
+		return nil, err
+	}
+	relpConn.tearDown()
+	hostname, port := relpConn.lastIp, relpConn.lastPort
+	relpConn.mutex.Unlock()
+
+	success, connErr := relpConn.ConnectContext(ctx, hostname, port)
+	if success {
+		relpConn.logger().Info("Reconnected to RELP server", "attempt", attempt)
+		policy.emit(EVENT_RECONNECTED, attempt, nil)
+		reader, _ := relpConn.session()
+		return reader, nil
+	}
+	if connErr == nil {
+		connErr = &Errors.ConnectionEstablishmentError{
+			Hostname: hostname,
+			Port:     port,
+			Reason:   "open was not acknowledged",
+			Protocol: "tcp",
+		}
+	}
+	return nil, connErr
+}
+
+// commit sends the batch using the session of the reader. The connection is torn down if the server closed it.
+func (relpConn *RelpConnection) commit(ctx context.Context, reader *relpReader, batch *RelpBatch.RelpBatch) error {
+	err := relpConn.sendBatch(ctx, reader, batch)
+	var serverCloseErr *Errors.ServerCloseError
+	if errors.As(err, &serverCloseErr) {
+		relpConn.tearDownSession(reader)
+	}
+	return err
+}
+
+// closeOnServerClose tears down the session once its reader has stopped because the server sent serverclose,
+// so that the connection becomes CLOSED also while no commit is in progress
+func (relpConn *RelpConnection) closeOnServerClose(reader *relpReader) {
+	<-reader.done
+	var serverCloseErr *Errors.ServerCloseError
+	if errors.As(reader.err, &serverCloseErr) {
+		relpConn.tearDownSession(reader)
+	}
+}
+
+// beginCommit counts a commit as started if the connection is open, and returns the reader of the session.
+// A session closed by the server also counts if the ReconnectPolicy has been set, so that the commit reconnects.
+func (relpConn *RelpConnection) beginCommit() (*relpReader, bool) {
+	relpConn.mutex.Lock()
+	defer relpConn.mutex.Unlock()
+	if relpConn.state != STATE_OPEN && !relpConn.closedByServer() {
+		return nil, false
+	}
+	relpConn.commits++
+	return relpConn.reader, true
+}
+
+// closedByServer returns true if the ReconnectPolicy has been set and the last session was closed with
+// serverclose, the mutex must be held
+func (relpConn *RelpConnection) closedByServer() bool {
+	if relpConn.ReconnectPolicy == nil || relpConn.reader.isRunning() {
+		return false
+	}
+	var serverCloseErr *Errors.ServerCloseError
+	return errors.As(relpConn.reader.err, &serverCloseErr)
+}
+
+// endCommit counts a commit started with beginCommit as finished
+func (relpConn *RelpConnection) endCommit() {
+	relpConn.mutex.Lock()
+	defer relpConn.mutex.Unlock()
+	relpConn.commits--
+}
+
+// session returns the reader of the current session, and whether the session is open
+func (relpConn *RelpConnection) session() (*relpReader, bool) {
+	relpConn.mutex.Lock()
+	defer relpConn.mutex.Unlock()
+	return relpConn.reader, relpConn.state == STATE_OPEN
+}
+
+// currentState returns the state of the connection, which is COMMIT while the connection is open
+// and at least one commit is in progress
+func (relpConn *RelpConnection) currentState() int {
+	relpConn.mutex.Lock()
+	defer relpConn.mutex.Unlock()
+	if relpConn.state == STATE_OPEN && relpConn.commits > 0 {
+		return STATE_COMMIT
+	}
+	return relpConn.state
+}
+
+// Abort sends the abort command to the server and tears down the connection immediately without
+// waiting for a response. All requests pending in the window are failed with AbortError.
+func (relpConn *RelpConnection) Abort() error {
+	if relpConn.currentState() == STATE_CLOSED {
+		return relpConn.invalidStateError("abort", STATE_OPEN)
+	}
+
+	relpConn.sendMutex.Lock()
+	relpRequest := RelpFrame.TX{Frame: RelpFrame.Frame{
+		TransactionId: relpConn.nextTxId(),
+		Cmd:           RelpCommand.RELP_ABORT,
+		DataLength:    0,
+		Data:          nil,
+	}}
+	sendErr := relpConn.sendRelpRequest(context.Background(), &relpRequest)
+	// failed before tearing down, so that the commits waiting for them return AbortError
+	relpConn.failPending(&Errors.AbortError{})
+	relpConn.sendMutex.Unlock()
+
+	relpConn.TearDown()
+	return sendErr
+}
+
+// FormatMessage formats the syslog message with the connection's Formatter, RFC 5424 by default
+func (relpConn *RelpConnection) FormatMessage(msg *RelpSyslog.Message) ([]byte, error) {
+	return relpConn.Formatter.Format(msg)
+}
+
+// InsertMessage formats the syslog message with the connection's Formatter and inserts it to the batch
+func (relpConn *RelpConnection) InsertMessage(batch *RelpBatch.RelpBatch, msg *RelpSyslog.Message) (uint64, error) {
+	return relpConn.Formatter.Insert(batch, msg)
+}
+
 // SendBatch sends the RELP frames to the server in the given batch.
-// The frames are sent asynchronously, and the server ACKs are checked after sending.
+// Up to MaxWindowSize frames are sent before their ACKs are received, and sending only blocks
+// while the window is full. The ACKs are read by the reader goroutine, and SendBatch returns
+// once all of them have been received.
 func (relpConn *RelpConnection) SendBatch(batch *RelpBatch.RelpBatch) error {
-	log.Printf("SendBatch.Entry> Batch workQueue: %v request(s), Pending requests in window: %v\n",
-		batch.GetWorkQueueLen(), len(relpConn.Window.Pending))
+	reader, _ := relpConn.session()
+	return relpConn.sendBatch(context.Background(), reader, batch)
+}
+
+// sendBatch is SendBatch using the session of the reader, which stops sending and waiting once the context is done.
+// The window is shared with the other batches being sent, each frame is written as a whole under the send mutex.
+func (relpConn *RelpConnection) sendBatch(ctx context.Context, reader *relpReader, batch *RelpBatch.RelpBatch) error {
+	if batch.Logger == nil {
+		batch.Logger = relpConn.Logger
+	}
+	relpConn.logger().Debug("SendBatch.Entry", "workQueue", batch.GetWorkQueueLen(), "pending", relpConn.Window.Size())
+	sent := make([]uint64, 0, batch.GetWorkQueueLen())
 	// send a batch of requests
 	for batch.GetWorkQueueLen() > 0 {
+		if !reader.isRunning() {
+			// connection lost or closed by the server, nothing will be acknowledged anymore
+			relpConn.failBatch(reader, batch, reader.failure())
+			return reader.failure()
+		}
+
+		// window full, wait for the server to ACK before sending more
+		ackErr := relpConn.awaitWindow(ctx, reader, func() bool {
+			return relpConn.Window.Size() < relpConn.MaxWindowSize
+		})
+		if ackErr != nil {
+			// ACK timeout or other failure
+			relpConn.failBatch(reader, batch, ackErr)
+			return ackErr
+		}
+
+		relpConn.sendMutex.Lock()
+		if relpConn.reader != reader {
+			// the session has been replaced by connecting again
+			relpConn.sendMutex.Unlock()
+			relpConn.failBatch(reader, batch, reader.failure())
+			return reader.failure()
+		}
+		if relpConn.Window.Size() >= relpConn.MaxWindowSize {
+			// another batch filled the window in the meantime
+			relpConn.sendMutex.Unlock()
+			continue
+		}
+
 		reqId := batch.PopWorkQueue()
 		relpRequest, err := batch.GetRequest(reqId)
 		if err != nil {
-			log.Fatalln("Could not get request from batch")
+			relpConn.sendMutex.Unlock()
+			relpConn.failBatch(reader, batch, err)
+			return err
 		}
 
-		// relp Request-Response txId
-		// <txId is here> <command> <len> <data> NL
-		// make sure txId loops 1 - 999 999 999
-		if relpConn.txId >= 999_999_999 {
-			relpConn.txId = 1
-		} else {
-			relpConn.txId++
-		}
-		relpRequest.TransactionId = relpConn.txId
-		log.Printf("SendBatch> Sending request\n%v %v %v '%v'\nfrom batch\n", relpRequest.TransactionId, relpRequest.Cmd,
-			relpRequest.DataLength, string(relpRequest.Data))
+		batch.PutTransactionId(reqId, relpConn.nextTxId())
+		relpConn.logger().Debug("SendBatch> Sending request", "txnId", relpRequest.TransactionId,
+			"cmd", relpRequest.Cmd, "len", relpRequest.DataLength, "reqId", reqId)
 
-		relpConn.Window.PutPending(relpConn.txId, reqId)
-		log.Println("SendBatch> Put pending: ", relpConn.txId, reqId)
+		relpConn.Window.PutPending(relpRequest.TransactionId, reqId, batch)
+		sent = append(sent, reqId)
 
-		sendErr := relpConn.SendRelpRequest(relpRequest)
+		sendErr := relpConn.sendRelpRequest(ctx, relpRequest)
+		relpConn.sendMutex.Unlock()
 		if sendErr != nil {
-			log.Printf("Error sending relp request: '%v'\n", err.Error())
+			if sendErr != ctx.Err() {
+				// a partially written frame leaves the session unusable for all the batches
+				relpConn.tearDownSession(reader)
+				if ctx.Err() != nil {
+					// the write was interrupted
+					sendErr = ctx.Err()
+				}
+			}
+			relpConn.logger().Warn("Error sending relp request", "error", sendErr)
+			relpConn.failBatch(reader, batch, sendErr)
+			return sendErr
 		}
+	}
 
-		ackErr := relpConn.ReadAcks(batch)
-		if ackErr != nil {
-			// ACK timeout or other failure
-			return ackErr
+	ackErr := relpConn.readAcks(ctx, reader, batch)
+	if ackErr != nil {
+		return ackErr
+	}
+	// the requests may have been failed by aborting or connecting again while waiting
+	for _, reqId := range sent {
+		if failure := batch.GetFailure(reqId); failure != nil {
+			return failure
 		}
 	}
-
 	return nil
 }
 
-// ReadAcks reads the ACKs from the given batch.
+// ReadAcks waits until the reader goroutine has received the ACKs for all pending
+// requests of the given batch. Requests of other batches may still be pending.
 func (relpConn *RelpConnection) ReadAcks(batch *RelpBatch.RelpBatch) error {
-	log.Printf("ReadAcks.Entry> Reading ACKs for batchID: %v\n", batch.RequestId)
-	var parser *RelpParser.RelpParser = nil
-	notComplete := relpConn.Window.Size() > 0
-
-	for notComplete { // until window is empty
-		readBytes := 0
-		for { // until parse complete
-			if parser == nil {
-				parser = &RelpParser.RelpParser{}
-			}
+	reader, _ := relpConn.session()
+	return relpConn.readAcks(context.Background(), reader, batch)
+}
 
-			// set ACK timeout duration, default 30 sec
-			errDl := relpConn.RelpDialer.SetReadDeadline(relpConn.ackTimeoutDuration)
-			if errDl != nil {
-				return errors.New("error setting connection timeout")
-			}
-			n, err := relpConn.RelpDialer.Read(relpConn.preAllocRxBuffer)
-
-			if err != nil {
-				if errors.Is(err, os.ErrDeadlineExceeded) {
-					// reading timed out
-					return &Errors.AckReadingError{Reason: "timeout"}
-				} else if err == io.EOF {
-					return &Errors.AckReadingError{Reason: "eof"}
-				} else {
-					// other error
-					return &Errors.AckReadingError{Reason: "unexpected error: " + err.Error()}
-				}
-			} else {
-				readBytes += n
-			}
+// readAcks is ReadAcks using the session of the reader, which stops waiting once the context is done
+func (relpConn *RelpConnection) readAcks(ctx context.Context, reader *relpReader, batch *RelpBatch.RelpBatch) error {
+	relpConn.logger().Debug("ReadAcks.Entry", "pending", relpConn.Window.SizeOf(batch))
+	ackErr := relpConn.awaitWindow(ctx, reader, func() bool {
+		return relpConn.Window.SizeOf(batch) == 0
+	})
+	if ackErr != nil {
+		relpConn.failBatch(reader, batch, ackErr)
+		return ackErr
+	}
+	relpConn.logger().Debug("ReadAcks.Done")
+	return nil
+}
 
-			// parse all bytes in buffer
-			for i := 0; i < n; i++ {
-				parseErr := parser.Parse(relpConn.preAllocRxBuffer[i])
-				if parseErr != nil {
-					panic("parsing error: " + parseErr.Error())
-				}
-			}
+// logger returns the Logger, or slog.Default() if it has not been set
+func (relpConn *RelpConnection) logger() *slog.Logger {
+	return RelpLog.OrDefault(relpConn.Logger)
+}
 
-			if parser.IsComplete {
-				log.Printf("ReadAcks> Parsing complete, with %v byte(s) read\n", readBytes)
-				// resp read successfully
-				txnId := parser.FrameTxnId
-				if relpConn.Window.IsPending(txnId) {
-					reqId, err := relpConn.Window.GetPending(txnId)
-					if err != nil {
-						panic("Could not find given pending txnId from RelpWindow!")
-					}
-					response := RelpFrame.RX{
-						Frame: RelpFrame.Frame{
-							TransactionId: parser.FrameTxnId,
-							Cmd:           parser.FrameCmdString,
-							DataLength:    parser.FrameLen,
-							Data:          parser.FrameData.Bytes(),
-						},
-					}
-					batch.PutResponse(reqId, &response)
-					relpConn.Window.RemovePending(txnId)
-				}
+// invalidStateError creates the error for an operation that was attempted in the current state
+func (relpConn *RelpConnection) invalidStateError(operation string, expected int) error {
+	return &Errors.InvalidStateError{
+		Operation: operation,
+		Current:   stateName(relpConn.currentState()),
+		Expected:  stateName(expected),
+	}
+}
 
-				parser = nil
-				if relpConn.Window.Size() == 0 {
-					// window empty, can exit readAcks method
-					notComplete = false
-				}
-				break
-			}
+// stateName returns the name of the connection state
+func stateName(state int) string {
+	switch state {
+	case STATE_CLOSED:
+		return "CLOSED"
+	case STATE_OPEN:
+		return "OPEN"
+	case STATE_COMMIT:
+		return "COMMIT"
+	default:
+		return "UNKNOWN"
+	}
+}
+
+// nextTxId increments the RELP Request-Response txId and returns it, the send mutex must be held
+// <txId is here> <command> <len> <data> NL
+func (relpConn *RelpConnection) nextTxId() uint64 {
+	// make sure txId loops 1 - 999 999 999
+	if relpConn.txId >= 999_999_999 {
+		relpConn.txId = 1
+	} else {
+		relpConn.txId++
+	}
+	return relpConn.txId
+}
+
+// failPending removes all the requests from the window, and saves the error
+// as the reason of failure to the batches owning them
+func (relpConn *RelpConnection) failPending(err error) {
+	for _, pending := range relpConn.Window.TakeAll() {
+		pending.Batch.PutFailure(pending.RequestId, err)
+	}
+}
+
+// failBatch removes the requests of the batch from the window and saves the error as their reason of failure.
+// The senders waiting for room in the window are woken up.
+func (relpConn *RelpConnection) failBatch(reader *relpReader, batch *RelpBatch.RelpBatch, err error) {
+	for _, pending := range relpConn.Window.TakeBatch(batch) {
+		pending.Batch.PutFailure(pending.RequestId, err)
+	}
+	reader.signal()
+}
+
+// interruptWriteOnDone expires the write deadline of the connection once the context is done,
+// so that the blocking write of a single frame returns. The returned function stops watching the context,
+// and must be called before the next frame is written.
+func (relpConn *RelpConnection) interruptWriteOnDone(ctx context.Context) func() {
+	if ctx.Done() == nil {
+		// context can't be cancelled
+		return func() {}
+	}
+	var mutex sync.Mutex
+	writing := true
+	stop := context.AfterFunc(ctx, func() {
+		mutex.Lock()
+		defer mutex.Unlock()
+		if writing {
+			_ = relpConn.RelpDialer.SetWriteDeadline(0)
 		}
+	})
+	return func() {
+		stop()
+		mutex.Lock()
+		writing = false
+		mutex.Unlock()
+	}
+}
 
+// awaitWindow blocks until ready returns true, checking it again each time the reader resolves a response.
+// Returns AckReadingError if no ACK is received within the ACK timeout, the reader's error if it has stopped,
+// and ctx.Err() if the context is done.
+func (relpConn *RelpConnection) awaitWindow(ctx context.Context, reader *relpReader, ready func() bool) error {
+	timer := time.NewTimer(relpConn.ackTimeoutDuration)
+	defer timer.Stop()
+	for {
+		// taken before checking, so that a response resolved in between is not missed
+		acks := reader.acks()
+		if ready() {
+			return nil
+		}
+		select {
+		case <-acks:
+			// got an ACK, restart the timeout
+			if !timer.Stop() {
+				<-timer.C
+			}
+			timer.Reset(relpConn.ackTimeoutDuration)
+		case <-reader.done:
+			// the reader may have received the last ACKs before stopping
+			if !ready() {
+				return reader.failure()
+			}
+			return nil
+		case <-timer.C:
+			return &Errors.AckReadingError{Reason: "timeout"}
+		case <-ctx.Done():
+			return ctx.Err()
+		}
 	}
-	log.Println("ReadAcks.Done> Return with no errors")
-	return nil
 }
 
 // SendRelpRequest sends the RELP frame to the connected RELP server
 func (relpConn *RelpConnection) SendRelpRequest(tx *RelpFrame.TX) error {
+	relpConn.sendMutex.Lock()
+	defer relpConn.sendMutex.Unlock()
+	return relpConn.sendRelpRequest(context.Background(), tx)
+}
+
+// sendRelpRequest is SendRelpRequest which does not start writing if the context is already done,
+// and interrupts the write once it is. The send mutex must be held.
+func (relpConn *RelpConnection) sendRelpRequest(ctx context.Context, tx *RelpFrame.TX) error {
+	// a failed write must not leave the frame in the buffer for the next request
+	defer relpConn.preAllocTxBuffer.Reset()
 	txN, err := tx.Write(relpConn.preAllocTxBuffer)
 
 	if err != nil {
@@ -301,15 +805,19 @@ func (relpConn *RelpConnection) SendRelpRequest(tx *RelpFrame.TX) error {
 	if dlErr != nil {
 		return dlErr
 	}
+	// checked after setting the deadline, as a cancellation before it would have been overridden
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	stopInterrupt := relpConn.interruptWriteOnDone(ctx)
 	n, writeErr := relpConn.RelpDialer.Write(relpConn.preAllocTxBuffer.Bytes())
+	stopInterrupt()
 
 	if writeErr != nil {
 		return writeErr
 	} else {
-		log.Printf("SendRelpRequest> Total of %v byte(s) written to server from %v given byte(s). (%v%%)",
-			n, txN, (1.00*n/txN)*100.0)
+		relpConn.logger().Debug("SendRelpRequest> Written to server", "written", n, "given", txN)
 	}
 
-	relpConn.preAllocTxBuffer.Reset()
 	return nil
 }
@@ -0,0 +1,143 @@
+package RelpConnection
+
+import (
+	"github.com/teragrep/rlp_05/internal/RelpCommand"
+	"github.com/teragrep/rlp_05/internal/RelpFrame"
+	"github.com/teragrep/rlp_05/internal/RelpParser"
+	"github.com/teragrep/rlp_05/internal/RelpWindow"
+	"github.com/teragrep/rlp_05/pkg/Errors"
+	"github.com/teragrep/rlp_05/pkg/RelpDialer"
+	"io"
+	"log/slog"
+	"sync"
+)
+
+// relpReader reads the response frames of a single RELP session in its own goroutine.
+// Responses to pending transactions are resolved using the RelpWindow and put to the batch owning the request.
+// Each resolved response is signalled to all the waiting senders by closing the ack channel, and the done channel
+// is closed once reading stops, either because reading from the connection failed or because the server sent serverclose.
+// The closed field is set, under the connection's mutex, when the session is closed on purpose.
+type relpReader struct {
+	dialer   RelpDialer.RelpDialer
+	window   *RelpWindow.RelpWindow
+	buffer   []byte
+	logger   *slog.Logger
+	ack      chan struct{}
+	ackMutex sync.Mutex
+	done     chan struct{}
+	err      error
+	closed   bool
+}
+
+// init initializes the reader for the connected dialer
+func (reader *relpReader) init(dialer RelpDialer.RelpDialer, window *RelpWindow.RelpWindow, buffer []byte, logger *slog.Logger) {
+	reader.dialer = dialer
+	reader.window = window
+	reader.buffer = buffer
+	reader.logger = logger
+	reader.ack = make(chan struct{})
+	reader.done = make(chan struct{})
+	reader.err = nil
+}
+
+// start starts reading in a new goroutine
+func (reader *relpReader) start() {
+	go reader.run()
+}
+
+// run reads and parses frames until reading from the connection fails.
+// The error is saved before the done channel is closed.
+func (reader *relpReader) run() {
+	defer close(reader.done)
+	parser := &RelpParser.RelpParser{Logger: reader.logger}
+	for {
+		n, err := reader.dialer.Read(reader.buffer)
+		if err != nil {
+			if err == io.EOF {
+				reader.err = &Errors.AckReadingError{Reason: "eof"}
+			} else {
+				reader.err = &Errors.AckReadingError{Reason: "unexpected error: " + err.Error()}
+			}
+			return
+		}
+
+		// a single read may contain multiple frames, or only a part of one
+		for i := 0; i < n; i++ {
+			parseErr := parser.Parse(reader.buffer[i])
+			if parseErr != nil {
+				reader.err = &Errors.ProtocolError{Err: parseErr}
+				return
+			}
+			if parser.IsComplete {
+				if parser.FrameCmdString == RelpCommand.RELP_SERVER_CLOSE {
+					// the server will not answer anymore, pending requests are failed by the senders
+					reader.logger.Info("RelpReader> Server closed the connection")
+					reader.err = &Errors.ServerCloseError{}
+					return
+				}
+				reader.handleFrame(parser)
+				parser = &RelpParser.RelpParser{Logger: reader.logger}
+			}
+		}
+	}
+}
+
+// handleFrame delivers the parsed response to the batch owning the pending transaction
+func (reader *relpReader) handleFrame(parser *RelpParser.RelpParser) {
+	pending, isPending := reader.window.TakePending(parser.FrameTxnId)
+	if !isPending {
+		reader.logger.Debug("RelpReader> Discarding frame which was not pending", "txnId", parser.FrameTxnId)
+		return
+	}
+
+	response := RelpFrame.RX{
+		Frame: RelpFrame.Frame{
+			TransactionId: parser.FrameTxnId,
+			Cmd:           parser.FrameCmdString,
+			DataLength:    parser.FrameLen,
+			Data:          parser.FrameData.Bytes(),
+		},
+	}
+	pending.Batch.PutResponse(pending.RequestId, &response)
+
+	reader.signal()
+}
+
+// acks returns the channel which is closed once the next response has been resolved
+func (reader *relpReader) acks() <-chan struct{} {
+	reader.ackMutex.Lock()
+	defer reader.ackMutex.Unlock()
+	return reader.ack
+}
+
+// signal wakes up all the senders waiting for a response, each of them checks the window itself
+func (reader *relpReader) signal() {
+	reader.ackMutex.Lock()
+	defer reader.ackMutex.Unlock()
+	if reader.ack != nil {
+		close(reader.ack)
+		reader.ack = make(chan struct{})
+	}
+}
+
+// failure returns the error which stopped the reader, or AckReadingError if it was never started.
+// Must only be called once the reader is not running.
+func (reader *relpReader) failure() error {
+	if reader.err == nil {
+		return &Errors.AckReadingError{Reason: "connection closed"}
+	}
+	return reader.err
+}
+
+// isRunning returns true if the reader has been started and has not stopped yet
+func (reader *relpReader) isRunning() bool {
+	if reader.done == nil {
+		return false
+	}
+	select {
+	case <-reader.done:
+		return false
+	default:
+		return true
+	}
+}
@@ -0,0 +1,75 @@
+package RelpConnection
+
+import (
+	"math/rand"
+	"time"
+)
+
+// constants for the reconnect event types (EVENT_ prefix)
+const (
+	EVENT_RECONNECTING = 0
+	EVENT_RECONNECTED  = 1
+	EVENT_GAVE_UP      = 2
+)
+
+// ReconnectEvent is passed to ReconnectPolicy.OnEvent. Attempt is the number of the reconnect attempt,
+// and Err is the error that caused the reconnect, or the last error when giving up.
+type ReconnectEvent struct {
+	Type    int
+	Attempt int
+	Err     error
+}
+
+// ReconnectPolicy configures how a failed commit is retried by reconnecting to the last used hostname and port.
+// The interval between attempts grows exponentially from InitialInterval up to MaxInterval, and is randomized
+// by +-Jitter (0.0 - 1.0) of its length. Zero MaxAttempts or MaxElapsedTime means no limit.
+type ReconnectPolicy struct {
+	InitialInterval time.Duration
+	MaxInterval     time.Duration
+	Multiplier      float64
+	Jitter          float64
+	MaxAttempts     int
+	MaxElapsedTime  time.Duration
+	OnEvent         func(event ReconnectEvent)
+}
+
+// Init initializes the policy with the default values
+func (policy *ReconnectPolicy) Init() {
+	policy.InitialInterval = 1 * time.Second
+	policy.MaxInterval = 30 * time.Second
+	policy.Multiplier = 2.0
+	policy.Jitter = 0.2
+	policy.MaxAttempts = 0
+	policy.MaxElapsedTime = 5 * time.Minute
+}
+
+// interval returns the randomized wait time before the given attempt. The first attempt is made immediately.
+func (policy *ReconnectPolicy) interval(attempt int) time.Duration {
+	if attempt <= 1 {
+		return 0
+	}
+	interval := float64(policy.InitialInterval)
+	for i := 2; i < attempt && interval < float64(policy.MaxInterval); i++ {
+		interval *= policy.Multiplier
+	}
+	if policy.MaxInterval > 0 && interval > float64(policy.MaxInterval) {
+		interval = float64(policy.MaxInterval)
+	}
+	interval += interval * policy.Jitter * (rand.Float64()*2 - 1)
+	return time.Duration(interval)
+}
+
+// isExhausted returns true if no more attempts are allowed after the given attempt
+func (policy *ReconnectPolicy) isExhausted(attempt int, start time.Time) bool {
+	if policy.MaxAttempts > 0 && attempt >= policy.MaxAttempts {
+		return true
+	}
+	return policy.MaxElapsedTime > 0 && time.Since(start) >= policy.MaxElapsedTime
+}
+
+// emit passes the event to OnEvent, if it has been set
+func (policy *ReconnectPolicy) emit(eventType int, attempt int, err error) {
+	if policy.OnEvent != nil {
+		policy.OnEvent(ReconnectEvent{Type: eventType, Attempt: attempt, Err: err})
+	}
+}
@@ -0,0 +1,68 @@
+package RelpConnection
+
+import (
+	"fmt"
+	"github.com/teragrep/rlp_05/internal/RelpCommand"
+	"github.com/teragrep/rlp_05/pkg/Errors"
+	"strconv"
+	"strings"
+)
+
+// RELP_VERSION is the only relp_version supported by the connection
+const RELP_VERSION int = 0
+
+// ServerOffer contains the offer the server sent in the response to the open command
+type ServerOffer struct {
+	Version  int
+	Software string
+	Commands []string
+}
+
+// SupportsCommand reports whether the server offered the given command
+func (offer *ServerOffer) SupportsCommand(cmd string) bool {
+	for _, offered := range offer.Commands {
+		if offered == cmd {
+			return true
+		}
+	}
+	return false
+}
+
+// parseServerOffer parses the data lines of the open response, one name=value pair per line.
+// Unknown offers are ignored.
+func parseServerOffer(lines []string) (*ServerOffer, error) {
+	offer := &ServerOffer{}
+	hasVersion := false
+	for _, line := range lines {
+		if line == "" {
+			continue
+		}
+		name, value, _ := strings.Cut(line, "=")
+		switch name {
+		case "relp_version":
+			version, err := strconv.Atoi(value)
+			if err != nil {
+				return nil, &Errors.OfferNegotiationError{Reason: fmt.Sprintf("relp_version %q is not a number", value)}
+			}
+			offer.Version = version
+			hasVersion = true
+		case "relp_software":
+			offer.Software = value
+		case "commands":
+			offer.Commands = strings.Split(value, ",")
+		}
+	}
+
+	if !hasVersion {
+		return nil, &Errors.OfferNegotiationError{Reason: "server did not offer relp_version"}
+	}
+	if offer.Version != RELP_VERSION {
+		return nil, &Errors.OfferNegotiationError{
+			Reason: fmt.Sprintf("server offered relp_version %v; want %v", offer.Version, RELP_VERSION),
+		}
+	}
+	if !offer.SupportsCommand(RelpCommand.RELP_SYSLOG) {
+		return nil, &Errors.OfferNegotiationError{Reason: "server does not support the syslog command"}
+	}
+	return offer, nil
+}
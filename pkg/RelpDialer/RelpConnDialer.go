@@ -0,0 +1,51 @@
+package RelpDialer
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"strconv"
+)
+
+// RelpConnDialer uses an already established net.Conn as the connection, e.g. one end of net.Pipe.
+// The connection can only be dialed once; reconnecting requires a new dialer.
+// If Encrypt is set, the TLS handshake is run over the connection using the tls.Config given to Dial.
+type RelpConnDialer struct {
+	Encrypt bool
+	conn    net.Conn
+	netConnection
+}
+
+// NewRelpConnDialer creates a dialer using the given connection
+func NewRelpConnDialer(conn net.Conn) *RelpConnDialer {
+	return &RelpConnDialer{conn: conn}
+}
+
+// Dial takes the wrapped connection into use, the hostname and port are ignored.
+// Returns boolean if the connection is encrypted or not and possible errors as the second return value.
+func (relpd *RelpConnDialer) Dial(hostname string, port int, cfg *tls.Config) (bool, error) {
+	return relpd.DialContext(context.Background(), hostname, port, cfg)
+}
+
+// DialContext takes the wrapped connection into use, running the TLS handshake with the given tls.Config
+// if Encrypt is set. The hostname is used for verifying the server, unless the config sets the ServerName.
+// Returns boolean if the connection is encrypted or not and possible errors as the second return value.
+func (relpd *RelpConnDialer) DialContext(ctx context.Context, hostname string, port int, cfg *tls.Config) (bool, error) {
+	if relpd.conn == nil {
+		return relpd.Encrypt, errors.New("wrapped connection has already been used")
+	}
+	conn := relpd.conn
+	relpd.conn = nil
+	if !relpd.Encrypt {
+		relpd.connection = conn
+		return false, nil
+	}
+
+	tlsConn, err := clientHandshake(ctx, conn, hostname, net.JoinHostPort(hostname, strconv.Itoa(port)), cfg)
+	if err != nil {
+		return true, err
+	}
+	relpd.connection = tlsConn
+	return true, nil
+}
@@ -1,6 +1,7 @@
 package RelpDialer
 
 import (
+	"context"
 	"crypto/tls"
 	"time"
 )
@@ -22,3 +23,11 @@ type RelpDialer interface {
 	// Close closes the connection, returning any possible errors.
 	Close() error
 }
+
+// RelpContextDialer is implemented by the RELP dialers which can abort dialing once a context is done.
+// Dialers without it are dialed with Dial, ignoring the context.
+type RelpContextDialer interface {
+	RelpDialer
+	// DialContext works like Dial, but aborts dialing once the context is done.
+	DialContext(ctx context.Context, hostname string, port int, cfg *tls.Config) (bool, error)
+}
@@ -0,0 +1,67 @@
+package RelpDialer
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"github.com/teragrep/rlp_05/pkg/Errors"
+	"net"
+	"net/http"
+)
+
+// RelpHTTPProxy tunnels connections through the HTTP proxy at the Address ("host:port") with the CONNECT method,
+// using basic authentication if the Username is set. The proxy is connected with the Dialer if set.
+// DialContext is used as the DialFunc of RelpPlainDialer or RelpTLSDialer, which then runs RELP over the tunnel.
+type RelpHTTPProxy struct {
+	Address  string
+	Username string
+	Password string
+	Dialer   *net.Dialer
+}
+
+// DialContext connects to the proxy, and asks it to connect to the address. Returns the tunnelled connection,
+// or ProxyError if the proxy refused.
+func (proxy *RelpHTTPProxy) DialContext(ctx context.Context, _ string, address string) (net.Conn, error) {
+	conn, handshakeDone, err := dialProxy(ctx, proxy.Dialer, proxy.Address)
+	if err != nil {
+		return nil, err
+	}
+	tunnel, err := proxy.connect(conn, address)
+	handshakeDone()
+	if err != nil {
+		_ = conn.Close()
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, err
+	}
+	return tunnel, nil
+}
+
+// connect sends the CONNECT request and reads the response of the proxy
+func (proxy *RelpHTTPProxy) connect(conn net.Conn, address string) (net.Conn, error) {
+	request := fmt.Sprintf("CONNECT %v HTTP/1.1\r\nHost: %v\r\n", address, address)
+	if proxy.Username != "" {
+		credentials := base64.StdEncoding.EncodeToString([]byte(proxy.Username + ":" + proxy.Password))
+		request += "Proxy-Authorization: Basic " + credentials + "\r\n"
+	}
+	request += "\r\n"
+	if _, err := conn.Write([]byte(request)); err != nil {
+		return nil, err
+	}
+
+	reader := bufio.NewReader(conn)
+	response, err := http.ReadResponse(reader, &http.Request{Method: http.MethodConnect})
+	if err != nil {
+		return nil, err
+	}
+	_ = response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return nil, &Errors.ProxyError{Proxy: proxy.Address, Reason: response.Status}
+	}
+	if reader.Buffered() > 0 {
+		return &bufferedConn{Conn: conn, reader: reader}, nil
+	}
+	return conn, nil
+}
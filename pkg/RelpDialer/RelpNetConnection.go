@@ -0,0 +1,53 @@
+package RelpDialer
+
+import (
+	"errors"
+	"net"
+	"time"
+)
+
+// netConnection holds the net.Conn of a dialer, and implements the reading, writing, deadline and closing
+// methods of RelpDialer for it
+type netConnection struct {
+	connection net.Conn
+}
+
+// Write writes the byte array to the connection
+func (netConn *netConnection) Write(src []byte) (int, error) {
+	if netConn.connection != nil {
+		return netConn.connection.Write(src)
+	}
+	return -1, errors.New("connection not available for writing")
+}
+
+// Read reads the incoming data to the specified byte array
+func (netConn *netConnection) Read(dest []byte) (int, error) {
+	if netConn.connection != nil {
+		return netConn.connection.Read(dest)
+	}
+	return -1, errors.New("connection not available for reading")
+}
+
+// SetReadDeadline sets the deadline for reading. The given duration is added on current time.
+func (netConn *netConnection) SetReadDeadline(dur time.Duration) error {
+	if netConn.connection != nil {
+		return netConn.connection.SetReadDeadline(time.Now().Add(dur))
+	}
+	return errors.New("connection not available for read deadline configuration")
+}
+
+// SetWriteDeadline sets the deadline for writing. The given duration is added on current time.
+func (netConn *netConnection) SetWriteDeadline(dur time.Duration) error {
+	if netConn.connection != nil {
+		return netConn.connection.SetWriteDeadline(time.Now().Add(dur))
+	}
+	return errors.New("connection not available for write deadline configuration")
+}
+
+// Close closes the connection
+func (netConn *netConnection) Close() error {
+	if netConn.connection != nil {
+		return netConn.connection.Close()
+	}
+	return errors.New("connection not available to close the connection")
+}
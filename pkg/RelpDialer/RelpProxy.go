@@ -0,0 +1,41 @@
+package RelpDialer
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"time"
+)
+
+// dialProxy connects to the proxy with the dialer, or a default net.Dialer, and interrupts the handshake
+// with the proxy once the context is done. The returned function must be called after the handshake.
+func dialProxy(ctx context.Context, dialer *net.Dialer, proxy string) (net.Conn, func(), error) {
+	if dialer == nil {
+		dialer = &net.Dialer{}
+	}
+	conn, err := dialer.DialContext(ctx, "tcp", proxy)
+	if err != nil {
+		return nil, nil, err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+	stop := context.AfterFunc(ctx, func() {
+		_ = conn.SetDeadline(time.Unix(1, 0))
+	})
+	return conn, func() {
+		stop()
+		_ = conn.SetDeadline(time.Time{})
+	}, nil
+}
+
+// bufferedConn is a net.Conn reading first the data left buffered by the proxy handshake
+type bufferedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+// Read reads from the buffer, and from the connection once the buffer is empty
+func (conn *bufferedConn) Read(dest []byte) (int, error) {
+	return conn.reader.Read(dest)
+}
@@ -0,0 +1,216 @@
+package RelpDialer
+
+import (
+	"context"
+	"github.com/teragrep/rlp_05/pkg/Errors"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Resolver looks up the SRV records and addresses dialed. *net.Resolver implements it,
+// and a stub can be used in its place for testing.
+type Resolver interface {
+	LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error)
+	LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error)
+}
+
+// DEFAULT_FALLBACK_DELAY is the time to wait for a connection attempt before starting the next one in parallel
+const DEFAULT_FALLBACK_DELAY = 300 * time.Millisecond
+
+// dialFunc connects to a single resolved address
+type dialFunc func(ctx context.Context, address string) (net.Conn, error)
+
+// dialResolved connects to the hostname and port. A hostname starting with an underscore, e.g.
+// _relp._tcp.example.com, is looked up as an SRV record and its targets are dialed in the order of priority
+// and weight. All addresses of a host are tried, happy-eyeballs style; alternating between IPv6 and IPv4,
+// and starting the next attempt if the previous one has not connected within fallbackDelay.
+// A negative fallbackDelay tries the addresses one at a time. Returns the connection and the host name
+// dialed, or a DialError with the address of the last failed attempt.
+func dialResolved(ctx context.Context, resolver Resolver, fallbackDelay time.Duration, hostname string, port int,
+	dial dialFunc) (net.Conn, string, error) {
+	if resolver == nil {
+		resolver = net.DefaultResolver
+	}
+	if fallbackDelay == 0 {
+		fallbackDelay = DEFAULT_FALLBACK_DELAY
+	}
+
+	if !strings.HasPrefix(hostname, "_") {
+		conn, err := dialHost(ctx, resolver, fallbackDelay, hostname, port, dial)
+		return conn, hostname, err
+	}
+
+	_, records, err := resolver.LookupSRV(ctx, "", "", hostname)
+	if err != nil {
+		return nil, hostname, &Errors.DialError{Network: "tcp", Address: hostname, Err: err}
+	}
+	var lastErr error = &Errors.DialError{
+		Network: "tcp",
+		Address: hostname,
+		Err:     &net.DNSError{Err: "no SRV records", Name: hostname},
+	}
+	for _, record := range records {
+		target := strings.TrimSuffix(record.Target, ".")
+		conn, dialErr := dialHost(ctx, resolver, fallbackDelay, target, int(record.Port), dial)
+		if dialErr == nil {
+			return conn, target, nil
+		}
+		lastErr = dialErr
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return nil, hostname, lastErr
+}
+
+// dialHost resolves the addresses of the host and dials them, see dialResolved
+func dialHost(ctx context.Context, resolver Resolver, fallbackDelay time.Duration, host string, port int,
+	dial dialFunc) (net.Conn, error) {
+	var addresses []string
+	if ip := net.ParseIP(host); ip != nil {
+		addresses = []string{net.JoinHostPort(host, strconv.Itoa(port))}
+	} else {
+		ips, err := resolver.LookupIPAddr(ctx, host)
+		if err != nil {
+			return nil, &Errors.DialError{
+				Network: "tcp",
+				Address: net.JoinHostPort(host, strconv.Itoa(port)),
+				Err:     err,
+			}
+		}
+		for _, ip := range interleaveFamilies(ips) {
+			addresses = append(addresses, net.JoinHostPort(ip.String(), strconv.Itoa(port)))
+		}
+	}
+	if len(addresses) == 0 {
+		return nil, &Errors.DialError{
+			Network: "tcp",
+			Address: net.JoinHostPort(host, strconv.Itoa(port)),
+			Err:     &net.DNSError{Err: "no addresses", Name: host},
+		}
+	}
+	return dialParallel(ctx, addresses, fallbackDelay, dial)
+}
+
+// interleaveFamilies orders the addresses alternating between the families, starting with the family
+// of the first address
+func interleaveFamilies(ips []net.IPAddr) []net.IPAddr {
+	var primary, secondary []net.IPAddr
+	for _, ip := range ips {
+		if len(primary) == 0 || (ip.IP.To4() == nil) == (primary[0].IP.To4() == nil) {
+			primary = append(primary, ip)
+		} else {
+			secondary = append(secondary, ip)
+		}
+	}
+	interleaved := make([]net.IPAddr, 0, len(ips))
+	for i := 0; i < len(primary) || i < len(secondary); i++ {
+		if i < len(primary) {
+			interleaved = append(interleaved, primary[i])
+		}
+		if i < len(secondary) {
+			interleaved = append(interleaved, secondary[i])
+		}
+	}
+	return interleaved
+}
+
+// dialResult is the outcome of a single connection attempt
+type dialResult struct {
+	conn    net.Conn
+	address string
+	err     error
+}
+
+// dialParallel dials the addresses in order, starting the next attempt once the previous one fails,
+// or has not connected within fallbackDelay. The first connection is returned and the others are closed.
+func dialParallel(ctx context.Context, addresses []string, fallbackDelay time.Duration, dial dialFunc) (net.Conn, error) {
+	attemptCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	results := make(chan dialResult, len(addresses))
+	next := 0
+	running := 0
+	startNext := func() {
+		address := addresses[next]
+		next++
+		running++
+		go func() {
+			conn, err := dial(attemptCtx, address)
+			results <- dialResult{conn: conn, address: address, err: err}
+		}()
+	}
+
+	var timer *time.Timer
+	var fallback <-chan time.Time
+	resetFallback := func() {
+		if timer != nil {
+			timer.Stop()
+		}
+		fallback = nil
+		if fallbackDelay > 0 && next < len(addresses) {
+			timer = time.NewTimer(fallbackDelay)
+			fallback = timer.C
+		}
+	}
+	defer func() {
+		if timer != nil {
+			timer.Stop()
+		}
+	}()
+
+	startNext()
+	resetFallback()
+	var lastErr error
+	for running > 0 {
+		select {
+		case result := <-results:
+			running--
+			if result.err == nil {
+				// close the connections of the attempts still running, once they finish
+				go func(remaining int) {
+					for i := 0; i < remaining; i++ {
+						if late := <-results; late.conn != nil {
+							_ = late.conn.Close()
+						}
+					}
+				}(running)
+				return result.conn, nil
+			}
+			lastErr = &Errors.DialError{Network: "tcp", Address: result.address, Err: result.err}
+			if next < len(addresses) && ctx.Err() == nil {
+				startNext()
+				resetFallback()
+			}
+		case <-fallback:
+			startNext()
+			resetFallback()
+		}
+	}
+	return nil, lastErr
+}
+
+// dialTCP connects to the hostname and port over TCP. The dialFn is called with the unresolved hostname and
+// port if set, otherwise the resolved addresses are connected with the dialer, or a default net.Dialer.
+// Returns the connection and the host name dialed.
+func dialTCP(ctx context.Context, resolver Resolver, fallbackDelay time.Duration, dialer *net.Dialer,
+	dialFn func(ctx context.Context, network string, address string) (net.Conn, error),
+	hostname string, port int) (net.Conn, string, error) {
+	if dialFn != nil {
+		address := net.JoinHostPort(hostname, strconv.Itoa(port))
+		conn, err := dialFn(ctx, "tcp", address)
+		if err != nil {
+			return nil, hostname, &Errors.DialError{Network: "tcp", Address: address, Err: err}
+		}
+		return conn, hostname, nil
+	}
+
+	if dialer == nil {
+		dialer = &net.Dialer{}
+	}
+	dial := func(ctx context.Context, address string) (net.Conn, error) {
+		return dialer.DialContext(ctx, "tcp", address)
+	}
+	return dialResolved(ctx, resolver, fallbackDelay, hostname, port, dial)
+}
@@ -0,0 +1,177 @@
+package RelpDialer
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/teragrep/rlp_05/pkg/Errors"
+	"io"
+	"net"
+	"strconv"
+)
+
+// constants for the SOCKS5 protocol (SOCKS5_ prefix)
+const (
+	SOCKS5_VERSION          = 0x05
+	SOCKS5_AUTH_NONE        = 0x00
+	SOCKS5_AUTH_PASSWORD    = 0x02
+	SOCKS5_AUTH_UNSUPPORTED = 0xff
+	SOCKS5_CMD_CONNECT      = 0x01
+	SOCKS5_ATYP_IPV4        = 0x01
+	SOCKS5_ATYP_DOMAIN      = 0x03
+	SOCKS5_ATYP_IPV6        = 0x04
+)
+
+// socks5Replies are the reasons for the SOCKS5 reply codes
+var socks5Replies = map[byte]string{
+	0x01: "general SOCKS server failure",
+	0x02: "connection not allowed by ruleset",
+	0x03: "network unreachable",
+	0x04: "host unreachable",
+	0x05: "connection refused",
+	0x06: "TTL expired",
+	0x07: "command not supported",
+	0x08: "address type not supported",
+}
+
+// RelpSOCKS5Proxy tunnels connections through the SOCKS5 proxy at the Address ("host:port"), using
+// username/password authentication if the Username is set. Host names are resolved by the proxy.
+// The proxy is connected with the Dialer if set.
+// DialContext is used as the DialFunc of RelpPlainDialer or RelpTLSDialer, which then runs RELP over the tunnel.
+type RelpSOCKS5Proxy struct {
+	Address  string
+	Username string
+	Password string
+	Dialer   *net.Dialer
+}
+
+// DialContext connects to the proxy, and asks it to connect to the address. Returns the tunnelled connection,
+// or ProxyError if the proxy refused.
+func (proxy *RelpSOCKS5Proxy) DialContext(ctx context.Context, _ string, address string) (net.Conn, error) {
+	conn, handshakeDone, err := dialProxy(ctx, proxy.Dialer, proxy.Address)
+	if err != nil {
+		return nil, err
+	}
+	err = proxy.handshake(conn, address)
+	handshakeDone()
+	if err != nil {
+		_ = conn.Close()
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, err
+	}
+	return conn, nil
+}
+
+// handshake authenticates to the proxy and sends the CONNECT request for the address
+func (proxy *RelpSOCKS5Proxy) handshake(conn net.Conn, address string) error {
+	host, portStr, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return err
+	}
+
+	method := byte(SOCKS5_AUTH_NONE)
+	if proxy.Username != "" {
+		method = SOCKS5_AUTH_PASSWORD
+	}
+	if _, err = conn.Write([]byte{SOCKS5_VERSION, 1, method}); err != nil {
+		return err
+	}
+	reply := make([]byte, 2)
+	if _, err = io.ReadFull(conn, reply); err != nil {
+		return err
+	}
+	if reply[0] != SOCKS5_VERSION {
+		return &Errors.ProxyError{Proxy: proxy.Address, Reason: fmt.Sprintf("unexpected SOCKS version %v", reply[0])}
+	}
+	if reply[1] != method {
+		return &Errors.ProxyError{Proxy: proxy.Address, Reason: "authentication method was not accepted"}
+	}
+	if method == SOCKS5_AUTH_PASSWORD {
+		if err = proxy.authenticate(conn); err != nil {
+			return err
+		}
+	}
+
+	request := []byte{SOCKS5_VERSION, SOCKS5_CMD_CONNECT, 0}
+	if ip := net.ParseIP(host); ip == nil {
+		if len(host) > 255 {
+			return &Errors.ProxyError{Proxy: proxy.Address, Reason: "host name is too long"}
+		}
+		request = append(request, SOCKS5_ATYP_DOMAIN, byte(len(host)))
+		request = append(request, host...)
+	} else if ip4 := ip.To4(); ip4 != nil {
+		request = append(request, SOCKS5_ATYP_IPV4)
+		request = append(request, ip4...)
+	} else {
+		request = append(request, SOCKS5_ATYP_IPV6)
+		request = append(request, ip.To16()...)
+	}
+	request = binary.BigEndian.AppendUint16(request, uint16(port))
+	if _, err = conn.Write(request); err != nil {
+		return err
+	}
+	return proxy.readConnectReply(conn)
+}
+
+// authenticate sends the username and password, RFC 1929
+func (proxy *RelpSOCKS5Proxy) authenticate(conn net.Conn) error {
+	if len(proxy.Username) > 255 || len(proxy.Password) > 255 {
+		return errors.New("SOCKS5 username and password must be at most 255 bytes")
+	}
+	request := []byte{1, byte(len(proxy.Username))}
+	request = append(request, proxy.Username...)
+	request = append(request, byte(len(proxy.Password)))
+	request = append(request, proxy.Password...)
+	if _, err := conn.Write(request); err != nil {
+		return err
+	}
+	reply := make([]byte, 2)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return err
+	}
+	if reply[1] != 0 {
+		return &Errors.ProxyError{Proxy: proxy.Address, Reason: "authentication failed"}
+	}
+	return nil
+}
+
+// readConnectReply reads the reply to the CONNECT request, including the bound address
+func (proxy *RelpSOCKS5Proxy) readConnectReply(conn net.Conn) error {
+	reply := make([]byte, 4)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return err
+	}
+	if reply[1] != 0 {
+		reason, ok := socks5Replies[reply[1]]
+		if !ok {
+			reason = fmt.Sprintf("reply code %v", reply[1])
+		}
+		return &Errors.ProxyError{Proxy: proxy.Address, Reason: reason}
+	}
+
+	var addrLen int
+	switch reply[3] {
+	case SOCKS5_ATYP_IPV4:
+		addrLen = net.IPv4len
+	case SOCKS5_ATYP_IPV6:
+		addrLen = net.IPv6len
+	case SOCKS5_ATYP_DOMAIN:
+		length := make([]byte, 1)
+		if _, err := io.ReadFull(conn, length); err != nil {
+			return err
+		}
+		addrLen = int(length[0])
+	default:
+		return &Errors.ProxyError{Proxy: proxy.Address, Reason: fmt.Sprintf("unknown address type %v", reply[3])}
+	}
+	// the bound address and port are not needed
+	_, err := io.ReadFull(conn, make([]byte, addrLen+2))
+	return err
+}
@@ -1,65 +1,185 @@
 package RelpDialer
 
 import (
+	"context"
+	"crypto/sha256"
 	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
 	"errors"
 	"fmt"
+	"github.com/teragrep/rlp_05/pkg/Errors"
+	"net"
+	"strconv"
 	"time"
 )
 
-// RelpTLSDialer contains the encrypted tls.Conn connection struct
+// RelpTLSDialer contains the encrypted tls.Conn connection struct.
+// The hostname is resolved and connected like in RelpPlainDialer, using the Resolver, FallbackDelay,
+// Dialer and DialFunc.
+// In addition to the verification done by the tls.Config, the server's certificate chain must contain a public key
+// in PinnedSPKIHashes, and the server's certificate must have a subject common name or SAN in AllowedNames,
+// if they are set. Only the server's own certificate is pinned if the config skips the chain verification.
+// AllowedNames are only checked against a verified certificate, so they can't be used with InsecureSkipVerify
+// unless PinnedSPKIHashes are set; anyone can present a self-signed certificate with the allowed names.
 type RelpTLSDialer struct {
-	connection *tls.Conn
+	Resolver         Resolver
+	FallbackDelay    time.Duration
+	Dialer           *net.Dialer
+	DialFunc         func(ctx context.Context, network string, address string) (net.Conn, error)
+	PinnedSPKIHashes []string
+	AllowedNames     []string
+	netConnection
 }
 
 // Dial sets up the encrypted connection using the given tls.Config
 // Returns boolean if the connection is encrypted or not and possible errors as the second return value.
 func (relpd *RelpTLSDialer) Dial(hostname string, port int, cfg *tls.Config) (bool, error) {
-	conn, err := tls.Dial("tcp", fmt.Sprintf("%v:%v", hostname, port), cfg)
+	return relpd.DialContext(context.Background(), hostname, port, cfg)
+}
+
+// DialContext sets up the encrypted connection using the given tls.Config, aborting the dial
+// and the handshake once the context is done. The server is verified against the ServerName of the config,
+// or the dialed host name, which is the target of the SRV record if one was looked up.
+// Returns boolean if the connection is encrypted or not and possible errors as the second return value.
+func (relpd *RelpTLSDialer) DialContext(ctx context.Context, hostname string, port int, cfg *tls.Config) (bool, error) {
+	if len(relpd.AllowedNames) > 0 && len(relpd.PinnedSPKIHashes) == 0 && cfg != nil && cfg.InsecureSkipVerify {
+		return true, &Errors.PeerVerificationError{
+			Address: net.JoinHostPort(hostname, strconv.Itoa(port)),
+			Reason:  "AllowedNames can't verify the server when InsecureSkipVerify is set without PinnedSPKIHashes",
+		}
+	}
+	conn, host, err := dialTCP(ctx, relpd.Resolver, relpd.FallbackDelay, relpd.Dialer, relpd.DialFunc, hostname, port)
+	if err != nil {
+		return true, err
+	}
+
+	address := net.JoinHostPort(host, strconv.Itoa(port))
+	tlsConn, err := clientHandshake(ctx, conn, host, address, relpd.verifyingConfig(cfg))
 	if err != nil {
 		return true, err
-	} else {
-		relpd.connection = conn
 	}
+	relpd.connection = tlsConn
 	return true, nil
 }
 
-// Write writes the given byte array to the connection
-func (relpd *RelpTLSDialer) Write(src []byte) (int, error) {
-	if relpd.connection != nil {
-		return relpd.connection.Write(src)
+// SPKIHash returns the base64 encoded SHA-256 hash of the certificate's public key, used in PinnedSPKIHashes
+func SPKIHash(certificate *x509.Certificate) string {
+	hash := sha256.Sum256(certificate.RawSubjectPublicKeyInfo)
+	return base64.StdEncoding.EncodeToString(hash[:])
+}
+
+// verifyingConfig returns the config with the pinning and name checks added before its own VerifyConnection
+func (relpd *RelpTLSDialer) verifyingConfig(cfg *tls.Config) *tls.Config {
+	if len(relpd.PinnedSPKIHashes) == 0 && len(relpd.AllowedNames) == 0 {
+		return cfg
 	}
-	return -1, errors.New("tls connection not available for writing")
+	config := &tls.Config{}
+	if cfg != nil {
+		config = cfg.Clone()
+	}
+	verifyConnection := config.VerifyConnection
+	config.VerifyConnection = func(state tls.ConnectionState) error {
+		err := relpd.verifyPeer(state)
+		if err == nil && verifyConnection != nil {
+			err = verifyConnection(state)
+		}
+		return err
+	}
+	return config
 }
 
-// Read reads the data from the connection to the given byte array
-func (relpd *RelpTLSDialer) Read(dest []byte) (int, error) {
-	if relpd.connection != nil {
-		return relpd.connection.Read(dest)
+// verifyPeer checks the pinned public keys and allowed names against the server's certificates
+func (relpd *RelpTLSDialer) verifyPeer(state tls.ConnectionState) error {
+	if len(state.PeerCertificates) == 0 {
+		return &Errors.PeerVerificationError{Reason: "server sent no certificate"}
 	}
-	return -1, errors.New("tls connection not available for reading")
+	leaf := state.PeerCertificates[0]
+
+	if len(relpd.PinnedSPKIHashes) > 0 {
+		candidates := []*x509.Certificate{leaf}
+		for _, chain := range state.VerifiedChains {
+			candidates = append(candidates, chain...)
+		}
+		if !containsPin(relpd.PinnedSPKIHashes, candidates) {
+			return &Errors.PeerVerificationError{
+				Reason: fmt.Sprintf("no pinned public key in the certificate chain, server key is %v", SPKIHash(leaf)),
+			}
+		}
+	}
+
+	if len(relpd.AllowedNames) > 0 {
+		names := []string{leaf.Subject.CommonName}
+		names = append(names, leaf.DNSNames...)
+		names = append(names, leaf.EmailAddresses...)
+		for _, ip := range leaf.IPAddresses {
+			names = append(names, ip.String())
+		}
+		for _, uri := range leaf.URIs {
+			names = append(names, uri.String())
+		}
+		if !containsAny(relpd.AllowedNames, names) {
+			return &Errors.PeerVerificationError{
+				Reason: fmt.Sprintf("none of the certificate names %v are allowed", names),
+			}
+		}
+	}
+	return nil
 }
 
-// SetReadDeadline sets the deadline for reading operations. The duration is added on current time.
-func (relpd *RelpTLSDialer) SetReadDeadline(dur time.Duration) error {
-	if relpd.connection != nil {
-		return relpd.connection.SetReadDeadline(time.Now().Add(dur))
+// containsPin reports whether the public key of any of the certificates is pinned
+func containsPin(pins []string, certificates []*x509.Certificate) bool {
+	for _, certificate := range certificates {
+		hash := SPKIHash(certificate)
+		for _, pin := range pins {
+			if pin == hash {
+				return true
+			}
+		}
 	}
-	return errors.New("tls connection not available for read deadline configuration")
+	return false
 }
 
-// SetWriteDeadline sets the deadline for writing operations. The duration is added on current time.
-func (relpd *RelpTLSDialer) SetWriteDeadline(dur time.Duration) error {
-	if relpd.connection != nil {
-		return relpd.connection.SetWriteDeadline(time.Now().Add(dur))
+// containsAny reports whether any of the names is allowed
+func containsAny(allowed []string, names []string) bool {
+	for _, name := range names {
+		for _, allowedName := range allowed {
+			if name != "" && name == allowedName {
+				return true
+			}
+		}
 	}
-	return errors.New("tls connection not available for write deadline configuration")
+	return false
 }
 
-// Close closes the connection.
-func (relpd *RelpTLSDialer) Close() error {
-	if relpd.connection != nil {
-		return relpd.connection.Close()
+// clientHandshake runs the TLS handshake on the connection, verifying the server against the ServerName
+// of the config or the host. The connection is closed if the handshake fails, and the errors report
+// its remote address, or the dialed address if the connection has none.
+func clientHandshake(ctx context.Context, conn net.Conn, host string, dialed string, cfg *tls.Config) (*tls.Conn, error) {
+	config := &tls.Config{}
+	if cfg != nil {
+		config = cfg.Clone()
+	}
+	if config.ServerName == "" {
+		config.ServerName = host
+	}
+	tlsConn := tls.Client(conn, config)
+	err := tlsConn.HandshakeContext(ctx)
+	if err != nil {
+		_ = conn.Close()
+		address := dialed
+		if remoteAddr := conn.RemoteAddr(); remoteAddr != nil {
+			address = remoteAddr.String()
+		}
+		// failures verifying the server are reported as such, instead of failures to connect
+		var verificationErr *Errors.PeerVerificationError
+		var certificateErr *tls.CertificateVerificationError
+		if errors.As(err, &verificationErr) {
+			return nil, &Errors.PeerVerificationError{Address: address, Reason: verificationErr.Reason}
+		} else if errors.As(err, &certificateErr) {
+			return nil, &Errors.PeerVerificationError{Address: address, Reason: certificateErr.Err.Error()}
+		}
+		return nil, &Errors.DialError{Network: "tcp", Address: address, Err: err}
 	}
-	return errors.New("tls connection not available to close the connection")
+	return tlsConn, nil
 }
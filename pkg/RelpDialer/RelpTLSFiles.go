@@ -0,0 +1,150 @@
+package RelpDialer
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"github.com/teragrep/rlp_05/internal/RelpLog"
+	"github.com/teragrep/rlp_05/pkg/Errors"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+// RelpTLSFiles builds a tls.Config from PEM files; the client certificate and key for mutual TLS, the CA bundle
+// verifying the server, and the ServerName to verify, if it differs from the dialed hostname.
+// The certificate and key files are checked for changes on every handshake, so a rotated certificate is used
+// on the next connect. If the rotated files can't be loaded, the previous certificate is used.
+type RelpTLSFiles struct {
+	CertFile    string
+	KeyFile     string
+	CAFile      string
+	ServerName  string
+	Logger      *slog.Logger
+	roots       *x509.CertPool
+	certificate *tls.Certificate
+	modTimes    [2]time.Time
+	mutex       sync.Mutex
+}
+
+// Init loads and validates the files, returning TLSConfigError if any of them is unusable; unreadable,
+// a certificate not matching the key, expired or not valid for client authentication, or a CA bundle
+// without certificates
+func (files *RelpTLSFiles) Init() error {
+	files.mutex.Lock()
+	defer files.mutex.Unlock()
+	if files.CAFile != "" {
+		pem, err := os.ReadFile(files.CAFile)
+		if err != nil {
+			return &Errors.TLSConfigError{File: files.CAFile, Reason: err.Error()}
+		}
+		files.roots = x509.NewCertPool()
+		if !files.roots.AppendCertsFromPEM(pem) {
+			return &Errors.TLSConfigError{File: files.CAFile, Reason: "no certificates found"}
+		}
+	}
+
+	if files.CertFile != "" || files.KeyFile != "" {
+		certificate, modTimes, err := files.loadCertificate()
+		if err != nil {
+			return err
+		}
+		files.certificate = certificate
+		files.modTimes = modTimes
+	}
+	return nil
+}
+
+// Config returns a tls.Config using the loaded files. The client certificate is given with
+// GetClientCertificate, which reloads it if the files have changed.
+func (files *RelpTLSFiles) Config() *tls.Config {
+	files.mutex.Lock()
+	defer files.mutex.Unlock()
+	config := &tls.Config{
+		RootCAs:    files.roots,
+		ServerName: files.ServerName,
+		MinVersion: tls.VersionTLS12,
+	}
+	if files.certificate != nil {
+		config.GetClientCertificate = files.getClientCertificate
+	}
+	return config
+}
+
+// getClientCertificate returns the client certificate, reloading it first if the files have been modified
+func (files *RelpTLSFiles) getClientCertificate(_ *tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	files.mutex.Lock()
+	defer files.mutex.Unlock()
+	modTimes, err := files.statCertificate()
+	if err == nil && modTimes != files.modTimes {
+		certificate, loadedModTimes, loadErr := files.loadCertificate()
+		if loadErr != nil {
+			RelpLog.OrDefault(files.Logger).Warn("Could not reload the client certificate, using the previous one",
+				"error", loadErr)
+		} else {
+			RelpLog.OrDefault(files.Logger).Info("Reloaded the client certificate", "file", files.CertFile)
+			files.certificate = certificate
+			files.modTimes = loadedModTimes
+		}
+	}
+	return files.certificate, nil
+}
+
+// statCertificate returns the modification times of the certificate and key files
+func (files *RelpTLSFiles) statCertificate() ([2]time.Time, error) {
+	var modTimes [2]time.Time
+	for i, file := range []string{files.CertFile, files.KeyFile} {
+		info, err := os.Stat(file)
+		if err != nil {
+			return modTimes, &Errors.TLSConfigError{File: file, Reason: err.Error()}
+		}
+		modTimes[i] = info.ModTime()
+	}
+	return modTimes, nil
+}
+
+// loadCertificate loads the certificate and key, and checks that the certificate is currently valid
+// for client authentication. Returns the certificate with the modification times of the files.
+func (files *RelpTLSFiles) loadCertificate() (*tls.Certificate, [2]time.Time, error) {
+	modTimes, err := files.statCertificate()
+	if err != nil {
+		return nil, modTimes, err
+	}
+	certificate, err := tls.LoadX509KeyPair(files.CertFile, files.KeyFile)
+	if err != nil {
+		return nil, modTimes, &Errors.TLSConfigError{File: files.CertFile, Reason: err.Error()}
+	}
+	leaf, err := x509.ParseCertificate(certificate.Certificate[0])
+	if err != nil {
+		return nil, modTimes, &Errors.TLSConfigError{File: files.CertFile, Reason: err.Error()}
+	}
+	now := time.Now()
+	if now.Before(leaf.NotBefore) || now.After(leaf.NotAfter) {
+		return nil, modTimes, &Errors.TLSConfigError{
+			File:   files.CertFile,
+			Reason: fmt.Sprintf("certificate is only valid from %v to %v", leaf.NotBefore, leaf.NotAfter),
+		}
+	}
+	if !allowsClientAuth(leaf) {
+		return nil, modTimes, &Errors.TLSConfigError{
+			File:   files.CertFile,
+			Reason: "certificate is not valid for client authentication",
+		}
+	}
+	certificate.Leaf = leaf
+	return &certificate, modTimes, nil
+}
+
+// allowsClientAuth reports whether the extended key usage of the certificate allows client authentication
+func allowsClientAuth(certificate *x509.Certificate) bool {
+	if len(certificate.ExtKeyUsage) == 0 {
+		return true
+	}
+	for _, usage := range certificate.ExtKeyUsage {
+		if usage == x509.ExtKeyUsageClientAuth || usage == x509.ExtKeyUsageAny {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,43 @@
+package RelpDialer
+
+import (
+	"context"
+	"crypto/tls"
+	"github.com/teragrep/rlp_05/pkg/Errors"
+	"net"
+)
+
+// RelpUnixDialer contains the net.Conn struct used for unencrypted Unix domain socket connections.
+// The socket is connected at the Path, or at the hostname given to Dial if the Path is empty, and the port is
+// ignored. A path starting with '@' is an abstract socket on Linux. The Dialer is used for connecting if set.
+type RelpUnixDialer struct {
+	Path   string
+	Dialer *net.Dialer
+	netConnection
+}
+
+// Dial connects to the socket at the Path, or at the hostname if the Path is empty.
+// Returns boolean if the connection is encrypted or not and possible errors as the second return value.
+func (relpd *RelpUnixDialer) Dial(hostname string, port int, cfg *tls.Config) (bool, error) {
+	return relpd.DialContext(context.Background(), hostname, port, cfg)
+}
+
+// DialContext connects to the socket at the Path, or at the hostname if the Path is empty,
+// aborting once the context is done.
+// Returns boolean if the connection is encrypted or not and possible errors as the second return value.
+func (relpd *RelpUnixDialer) DialContext(ctx context.Context, hostname string, _ int, _ *tls.Config) (bool, error) {
+	path := relpd.Path
+	if path == "" {
+		path = hostname
+	}
+	dialer := relpd.Dialer
+	if dialer == nil {
+		dialer = &net.Dialer{}
+	}
+	conn, err := dialer.DialContext(ctx, "unix", path)
+	if err != nil {
+		return false, &Errors.DialError{Network: "unix", Address: path, Err: err}
+	}
+	relpd.connection = conn
+	return false, nil
+}
@@ -0,0 +1,285 @@
+package RelpPool
+
+import (
+	"context"
+	"github.com/teragrep/rlp_05/internal/RelpLog"
+	"github.com/teragrep/rlp_05/pkg/Errors"
+	"github.com/teragrep/rlp_05/pkg/RelpBatch"
+	"github.com/teragrep/rlp_05/pkg/RelpConnection"
+	"github.com/teragrep/rlp_05/pkg/RelpDialer"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// constants for the endpoint selection strategies (STRATEGY_ prefix)
+const (
+	STRATEGY_ROUND_ROBIN   = 0
+	STRATEGY_LEAST_PENDING = 1
+	STRATEGY_FAILOVER      = 2
+)
+
+// RelpPool commits batches to one of several RELP servers. The endpoint is selected by the Strategy;
+// in turns with STRATEGY_ROUND_ROBIN, by the fewest requests pending in the connection's window with
+// STRATEGY_LEAST_PENDING, or the first healthy one in the order of Endpoints with STRATEGY_FAILOVER.
+// An endpoint failing to connect or commit is marked unhealthy and skipped for RetryInterval, and the
+// unacknowledged requests of the batch are committed to the next healthy endpoint.
+// NewConnection creates the connection of an endpoint, a plain connection using the pool's Logger by default.
+type RelpPool struct {
+	Endpoints     []Endpoint
+	Strategy      int
+	RetryInterval time.Duration
+	NewConnection func(endpoint Endpoint) *RelpConnection.RelpConnection
+	Logger        *slog.Logger
+	members       []*poolMember
+	next          int
+	mutex         sync.Mutex
+}
+
+// Init initializes the pool with a connection for each of the Endpoints. The connections are opened on
+// Connect, or on the first commit to the endpoint.
+func (pool *RelpPool) Init() {
+	if pool.RetryInterval == 0 {
+		pool.RetryInterval = 30 * time.Second
+	}
+	if pool.NewConnection == nil {
+		pool.NewConnection = func(endpoint Endpoint) *RelpConnection.RelpConnection {
+			connection := &RelpConnection.RelpConnection{RelpDialer: &RelpDialer.RelpPlainDialer{}}
+			connection.Init()
+			connection.Logger = pool.Logger
+			return connection
+		}
+	}
+	pool.members = make([]*poolMember, len(pool.Endpoints))
+	for i, endpoint := range pool.Endpoints {
+		pool.members[i] = &poolMember{endpoint: endpoint, connection: pool.NewConnection(endpoint)}
+	}
+	pool.next = 0
+}
+
+// Connect connects to all the endpoints. Returns NoHealthyEndpointError if none of them could be connected.
+func (pool *RelpPool) Connect() error {
+	return pool.ConnectContext(context.Background())
+}
+
+// ConnectContext works like Connect, but stops connecting once the context is done
+func (pool *RelpPool) ConnectContext(ctx context.Context) error {
+	var lastErr error
+	connected := 0
+	for _, member := range pool.members {
+		member.mutex.Lock()
+		err := pool.connect(ctx, member)
+		member.mutex.Unlock()
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err != nil {
+			lastErr = err
+		} else {
+			connected++
+		}
+	}
+	if connected == 0 {
+		return &Errors.NoHealthyEndpointError{Err: lastErr}
+	}
+	return nil
+}
+
+// Commit commits the batch to an endpoint selected by the Strategy. If the endpoint fails, the unacknowledged
+// requests are committed to the next available endpoint. Returns NoHealthyEndpointError wrapping the last
+// error once every endpoint has been tried.
+func (pool *RelpPool) Commit(batch *RelpBatch.RelpBatch) error {
+	return pool.CommitContext(context.Background(), batch)
+}
+
+// CommitContext works like Commit, but stops committing once the context is done, returning ctx.Err()
+func (pool *RelpPool) CommitContext(ctx context.Context, batch *RelpBatch.RelpBatch) error {
+	tried := make(map[*poolMember]struct{})
+	var lastErr error
+	for {
+		member := pool.selectMember(tried)
+		if member == nil {
+			return &Errors.NoHealthyEndpointError{Err: lastErr}
+		}
+		tried[member] = struct{}{}
+
+		err := pool.commit(ctx, member, batch)
+		if err == nil || ctx.Err() != nil {
+			return err
+		}
+		lastErr = err
+		pool.logger().Warn("Failing over to the next RELP endpoint", "hostname", member.endpoint.Hostname,
+			"port", member.endpoint.Port, "error", err)
+		batch.RetryAllUnacknowledged()
+	}
+}
+
+// Disconnect disconnects from all the connected endpoints, returning the first error
+func (pool *RelpPool) Disconnect() error {
+	var firstErr error
+	for _, member := range pool.members {
+		member.mutex.Lock()
+		if member.connected {
+			_, err := member.connection.Disconnect()
+			if err != nil && firstErr == nil {
+				firstErr = err
+			}
+			member.connection.TearDown()
+			member.connected = false
+		}
+		member.mutex.Unlock()
+	}
+	return firstErr
+}
+
+// Status returns the health of each endpoint, in the order of Endpoints
+func (pool *RelpPool) Status() []EndpointStatus {
+	pool.mutex.Lock()
+	defer pool.mutex.Unlock()
+	status := make([]EndpointStatus, len(pool.members))
+	for i, member := range pool.members {
+		status[i] = EndpointStatus{
+			Endpoint:  member.endpoint,
+			Healthy:   member.isHealthy(),
+			Failures:  member.failures,
+			LastError: member.lastErr,
+		}
+	}
+	return status
+}
+
+// selectMember selects the next available member not yet tried, following the Strategy
+func (pool *RelpPool) selectMember(tried map[*poolMember]struct{}) *poolMember {
+	pool.mutex.Lock()
+	defer pool.mutex.Unlock()
+	now := time.Now()
+	var candidates []*poolMember
+	for i := range pool.members {
+		// round-robin starts from the member after the previously selected one
+		member := pool.members[(i+pool.next)%len(pool.members)]
+		if pool.Strategy != STRATEGY_ROUND_ROBIN {
+			member = pool.members[i]
+		}
+		if _, isTried := tried[member]; !isTried && member.isAvailable(now) {
+			candidates = append(candidates, member)
+		}
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+
+	selected := candidates[0]
+	switch pool.Strategy {
+	case STRATEGY_ROUND_ROBIN:
+		for i, member := range pool.members {
+			if member == selected {
+				pool.next = i + 1
+			}
+		}
+	case STRATEGY_LEAST_PENDING:
+		least := selected.pending()
+		for _, member := range candidates[1:] {
+			if pending := member.pending(); pending < least {
+				selected, least = member, pending
+			}
+		}
+	case STRATEGY_FAILOVER:
+		// prefer a healthy endpoint over retrying a failed one earlier in the list
+		for _, member := range candidates {
+			if member.isHealthy() {
+				selected = member
+				break
+			}
+		}
+	}
+	return selected
+}
+
+// commit commits the batch using the member's connection, connecting it first if needed.
+// The commit runs alongside the other commits to the member, a failed one tears down the connection it used.
+// A commit failing on a connection opened before it, e.g. one closed by the endpoint with serverclose while
+// restarting, is retried once on a new connection before the endpoint is counted as failed.
+func (pool *RelpPool) commit(ctx context.Context, member *poolMember, batch *RelpBatch.RelpBatch) error {
+	for reconnected := false; ; reconnected = true {
+		session, connected, err := pool.session(ctx, member)
+		if err != nil {
+			return err
+		}
+
+		err = member.connection.CommitContext(ctx, batch)
+		if ctx.Err() != nil {
+			// the connection fails only the requests of the canceled batch, and stays usable for the others
+			return err
+		}
+		if err != nil {
+			member.mutex.Lock()
+			if member.connected && member.session == session {
+				member.connection.TearDown()
+				member.connected = false
+			}
+			member.mutex.Unlock()
+			if !reconnected && !connected {
+				batch.RetryAllUnacknowledged()
+				continue
+			}
+		}
+		pool.updateHealth(member, err)
+		return err
+	}
+}
+
+// session connects the member's connection if needed, and returns the number of the session.
+// The boolean return value is true if the connection was connected by this call.
+func (pool *RelpPool) session(ctx context.Context, member *poolMember) (uint64, bool, error) {
+	member.mutex.Lock()
+	defer member.mutex.Unlock()
+	if member.connected {
+		return member.session, false, nil
+	}
+	err := pool.connect(ctx, member)
+	return member.session, true, err
+}
+
+// connect connects the member's connection and updates its health, the member's mutex must be held
+func (pool *RelpPool) connect(ctx context.Context, member *poolMember) error {
+	ok, err := member.connection.ConnectContext(ctx, member.endpoint.Hostname, member.endpoint.Port)
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if !ok && err == nil {
+		err = &Errors.ConnectionEstablishmentError{
+			Hostname: member.endpoint.Hostname,
+			Port:     member.endpoint.Port,
+			Reason:   "open was not acknowledged",
+			Protocol: "tcp",
+		}
+	}
+	if err != nil {
+		member.connection.TearDown()
+	}
+	member.connected = err == nil
+	member.session++
+	pool.updateHealth(member, err)
+	return err
+}
+
+// updateHealth marks the member healthy after a success, and unhealthy for RetryInterval after a failure
+func (pool *RelpPool) updateHealth(member *poolMember, err error) {
+	pool.mutex.Lock()
+	defer pool.mutex.Unlock()
+	if err == nil {
+		member.failures = 0
+		member.lastErr = nil
+		return
+	}
+	member.failures++
+	member.lastErr = err
+	member.retryAt = time.Now().Add(pool.RetryInterval)
+	pool.logger().Warn("RELP endpoint marked unhealthy", "hostname", member.endpoint.Hostname,
+		"port", member.endpoint.Port, "failures", member.failures, "error", err)
+}
+
+// logger returns the Logger, or slog.Default() if it has not been set
+func (pool *RelpPool) logger() *slog.Logger {
+	return RelpLog.OrDefault(pool.Logger)
+}
@@ -0,0 +1,51 @@
+package RelpPool
+
+import (
+	"github.com/teragrep/rlp_05/pkg/RelpConnection"
+	"sync"
+	"time"
+)
+
+// Endpoint is the address of a RELP server in the pool
+type Endpoint struct {
+	Hostname string
+	Port     int
+}
+
+// EndpointStatus is the health of an endpoint. Failures is the number of consecutive failures,
+// and LastError the error of the latest one.
+type EndpointStatus struct {
+	Endpoint  Endpoint
+	Healthy   bool
+	Failures  int
+	LastError error
+}
+
+// poolMember is an endpoint with its connection. The commits to the endpoint share the connection,
+// the mutex guards connecting and tearing it down. The session counts the connections made, so that a failed
+// commit only tears down the connection it used. The health fields are guarded by the pool's mutex.
+type poolMember struct {
+	endpoint   Endpoint
+	connection *RelpConnection.RelpConnection
+	connected  bool
+	session    uint64
+	mutex      sync.Mutex
+	failures   int
+	lastErr    error
+	retryAt    time.Time
+}
+
+// isHealthy reports whether the member has not failed since its last success
+func (member *poolMember) isHealthy() bool {
+	return member.failures == 0
+}
+
+// pending returns the amount of requests waiting for an ACK in the connection's window
+func (member *poolMember) pending() int {
+	return member.connection.Window.Size()
+}
+
+// isAvailable reports whether the member can be tried; healthy, or unhealthy for longer than the retry interval
+func (member *poolMember) isAvailable(now time.Time) bool {
+	return member.isHealthy() || !now.Before(member.retryAt)
+}
@@ -0,0 +1,202 @@
+package RelpServer
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"github.com/teragrep/rlp_05/internal/RelpLog"
+	"github.com/teragrep/rlp_05/internal/RelpParser"
+	"log/slog"
+	"net"
+	"sync"
+	"time"
+)
+
+// SyslogHandler is called for every syslog payload received by the server. Returning nil accepts the message
+// and the client is sent "200 OK", any returned error rejects the message and is sent to the client as
+// "500 <error>", with the line breaks of the error replaced by spaces.
+type SyslogHandler func(payload []byte) error
+
+// RelpServer struct contains the necessary fields to accept RELP connections
+// and deliver the received syslog messages to the Handler.
+// CloseTimeout limits how long Close waits for writing serverclose to a client which is not reading.
+// A client sending a malformed frame, or one with more than MaxFrameSize bytes of data, is sent serverclose
+// and disconnected, as is a client not completing a started frame within ReadTimeout, or not starting
+// the next one within IdleTimeout if it has been set.
+type RelpServer struct {
+	Handler      SyslogHandler
+	Software     string
+	Logger       *slog.Logger
+	CloseTimeout time.Duration
+	MaxFrameSize int
+	ReadTimeout  time.Duration
+	IdleTimeout  time.Duration
+	listener     net.Listener
+	sessions     map[*RelpSession]struct{}
+	mutex        sync.Mutex
+	waitGroup    sync.WaitGroup
+	closed       bool
+}
+
+// Init initializes the server with a handler accepting all messages, if the Handler has not been set
+func (srv *RelpServer) Init() {
+	if srv.Handler == nil {
+		srv.Handler = func(_ []byte) error { return nil }
+	}
+	if srv.Software == "" {
+		srv.Software = "RLP-05"
+	}
+	if srv.CloseTimeout <= 0 {
+		srv.CloseTimeout = 5 * time.Second
+	}
+	if srv.MaxFrameSize <= 0 {
+		srv.MaxFrameSize = RelpParser.DEFAULT_MAX_FRAME_LEN
+	}
+	if srv.ReadTimeout <= 0 {
+		srv.ReadTimeout = 30 * time.Second
+	}
+	srv.sessions = make(map[*RelpSession]struct{})
+	srv.closed = false
+}
+
+// Listen starts accepting unencrypted connections on the given hostname and port.
+// Accepting is done in the background, Close stops the server.
+func (srv *RelpServer) Listen(hostname string, port int) error {
+	listener, err := net.Listen("tcp", fmt.Sprintf("%v:%v", hostname, port))
+	if err != nil {
+		return err
+	}
+	return srv.Serve(listener)
+}
+
+// ListenTLS starts accepting encrypted connections on the given hostname and port using the tls.Config,
+// which must contain the server certificate. Accepting is done in the background, Close stops the server.
+func (srv *RelpServer) ListenTLS(hostname string, port int, cfg *tls.Config) error {
+	listener, err := tls.Listen("tcp", fmt.Sprintf("%v:%v", hostname, port), cfg)
+	if err != nil {
+		return err
+	}
+	return srv.Serve(listener)
+}
+
+// ListenUnix starts accepting unencrypted connections on the Unix domain socket at the path.
+// A path starting with '@' is an abstract socket on Linux. The socket file is removed on Close.
+// Accepting is done in the background, Close stops the server.
+func (srv *RelpServer) ListenUnix(path string) error {
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return err
+	}
+	return srv.Serve(listener)
+}
+
+// Serve starts accepting connections from the given listener in the background.
+func (srv *RelpServer) Serve(listener net.Listener) error {
+	srv.mutex.Lock()
+	defer srv.mutex.Unlock()
+	if srv.listener != nil {
+		return errors.New("server is already listening")
+	}
+	srv.listener = listener
+	srv.closed = false
+
+	srv.waitGroup.Add(1)
+	go srv.acceptLoop(listener)
+	return nil
+}
+
+// ServeConn serves a single already established connection in the background, e.g. one end of net.Pipe.
+// The session is closed with the others on Close.
+func (srv *RelpServer) ServeConn(conn net.Conn) error {
+	srv.mutex.Lock()
+	defer srv.mutex.Unlock()
+	if srv.closed {
+		_ = conn.Close()
+		return errors.New("server is closed")
+	}
+	srv.startSession(conn)
+	return nil
+}
+
+// Addr returns the address the server is listening on, or nil if the server is not listening
+func (srv *RelpServer) Addr() net.Addr {
+	srv.mutex.Lock()
+	defer srv.mutex.Unlock()
+	if srv.listener == nil {
+		return nil
+	}
+	return srv.listener.Addr()
+}
+
+// Close stops accepting new connections, closes all open sessions with serverclose and waits for them to finish.
+func (srv *RelpServer) Close() error {
+	srv.mutex.Lock()
+	if srv.listener == nil && len(srv.sessions) == 0 {
+		srv.mutex.Unlock()
+		return errors.New("server is not listening")
+	}
+	srv.closed = true
+	var err error
+	if srv.listener != nil {
+		err = srv.listener.Close()
+		srv.listener = nil
+	}
+	// written without holding the mutex, each session waiting for its own client at most CloseTimeout
+	for session := range srv.sessions {
+		srv.waitGroup.Add(1)
+		go func(session *RelpSession) {
+			defer srv.waitGroup.Done()
+			session.ServerClose()
+		}(session)
+	}
+	srv.mutex.Unlock()
+
+	srv.waitGroup.Wait()
+	return err
+}
+
+// acceptLoop accepts connections until the listener is closed, and serves each in its own goroutine
+func (srv *RelpServer) acceptLoop(listener net.Listener) {
+	defer srv.waitGroup.Done()
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			srv.mutex.Lock()
+			closed := srv.closed
+			srv.mutex.Unlock()
+			if !closed {
+				RelpLog.OrDefault(srv.Logger).Warn("RelpServer> Error accepting connection", "error", err)
+			}
+			return
+		}
+
+		srv.mutex.Lock()
+		if srv.closed {
+			srv.mutex.Unlock()
+			_ = conn.Close()
+			return
+		}
+		srv.startSession(conn)
+		srv.mutex.Unlock()
+	}
+}
+
+// startSession serves the connection in its own goroutine, the server's mutex must be held
+func (srv *RelpServer) startSession(conn net.Conn) {
+	session := &RelpSession{}
+	session.Init(conn, srv.Handler, srv.Software, RelpLog.OrDefault(srv.Logger))
+	session.closeTimeout = srv.CloseTimeout
+	session.maxFrameSize = srv.MaxFrameSize
+	session.readTimeout = srv.ReadTimeout
+	session.idleTimeout = srv.IdleTimeout
+	srv.sessions[session] = struct{}{}
+	srv.waitGroup.Add(1)
+
+	go func() {
+		defer srv.waitGroup.Done()
+		session.Serve()
+		srv.mutex.Lock()
+		delete(srv.sessions, session)
+		srv.mutex.Unlock()
+	}()
+}
@@ -0,0 +1,182 @@
+package RelpServer
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"github.com/teragrep/rlp_05/internal/RelpCommand"
+	"github.com/teragrep/rlp_05/internal/RelpFrame"
+	"github.com/teragrep/rlp_05/internal/RelpParser"
+	"github.com/teragrep/rlp_05/pkg/Errors"
+	"io"
+	"log/slog"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RelpSession contains a single client connection of the RelpServer
+type RelpSession struct {
+	connection   net.Conn
+	reader       *bufio.Reader
+	txBuffer     *bytes.Buffer
+	txMutex      sync.Mutex
+	handler      SyslogHandler
+	logger       *slog.Logger
+	offer        []byte
+	open         bool
+	closeTimeout time.Duration
+	maxFrameSize int
+	readTimeout  time.Duration
+	idleTimeout  time.Duration
+}
+
+// Init initializes the session for the given connection
+func (session *RelpSession) Init(conn net.Conn, handler SyslogHandler, software string, logger *slog.Logger) {
+	session.logger = logger
+	session.connection = conn
+	session.reader = bufio.NewReader(conn)
+	session.txBuffer = bytes.NewBuffer(make([]byte, 0, 512))
+	session.handler = handler
+	session.offer = []byte("200 OK\nrelp_version=0\nrelp_software=" + software + "\ncommands=" + RelpCommand.RELP_SYSLOG + "\n")
+	session.open = false
+	session.closeTimeout = 5 * time.Second
+	session.maxFrameSize = RelpParser.DEFAULT_MAX_FRAME_LEN
+	session.readTimeout = 30 * time.Second
+	session.idleTimeout = 0
+}
+
+// Serve reads and answers the request frames until the client closes the session or the connection fails.
+// A malformed frame or a read timeout closes the session with serverclose.
+func (session *RelpSession) Serve() {
+	defer session.Close()
+	for {
+		request, err := session.readFrame()
+		var parsingErr *Errors.ResponseParsingError
+		var netErr net.Error
+		if errors.As(err, &parsingErr) || (errors.As(err, &netErr) && netErr.Timeout()) {
+			session.logger.Warn("RelpSession> Closing session", "error", err)
+			session.ServerClose()
+			return
+		}
+		if err != nil {
+			if !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) {
+				session.logger.Warn("RelpSession> Error reading request", "error", err)
+			}
+			return
+		}
+
+		if !session.handleFrame(request) {
+			return
+		}
+	}
+}
+
+// Close closes the connection of the session
+func (session *RelpSession) Close() {
+	_ = session.connection.Close()
+}
+
+// ServerClose notifies the client with serverclose and closes the connection of the session.
+// Writing gives up once the close timeout has passed, also for a response already being written,
+// so that a client which is not reading can't keep the session open.
+func (session *RelpSession) ServerClose() {
+	_ = session.connection.SetWriteDeadline(time.Now().Add(session.closeTimeout))
+	_ = session.write(&RelpFrame.TX{Frame: RelpFrame.Frame{Cmd: RelpCommand.RELP_SERVER_CLOSE}})
+	session.Close()
+}
+
+// readFrame reads a single request frame from the connection, waiting at most the idle timeout for it to start,
+// and the read timeout for it to complete
+func (session *RelpSession) readFrame() (*RelpFrame.RX, error) {
+	parser := &RelpParser.RelpParser{Logger: session.logger, MaxFrameLen: session.maxFrameSize}
+	deadline := time.Time{}
+	if session.idleTimeout > 0 {
+		deadline = time.Now().Add(session.idleTimeout)
+	}
+	if err := session.connection.SetReadDeadline(deadline); err != nil {
+		return nil, err
+	}
+	for started := false; !parser.IsComplete; started = true {
+		b, err := session.reader.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		if !started {
+			if err = session.connection.SetReadDeadline(time.Now().Add(session.readTimeout)); err != nil {
+				return nil, err
+			}
+		}
+		parseErr := parser.Parse(b)
+		if parseErr != nil {
+			return nil, parseErr
+		}
+	}
+
+	return &RelpFrame.RX{
+		Frame: RelpFrame.Frame{
+			TransactionId: parser.FrameTxnId,
+			Cmd:           parser.FrameCmdString,
+			DataLength:    parser.FrameLen,
+			Data:          parser.FrameData.Bytes(),
+		},
+	}, nil
+}
+
+// handleFrame answers the request frame. Returns false if the session should be closed.
+func (session *RelpSession) handleFrame(request *RelpFrame.RX) bool {
+	switch request.Cmd {
+	case RelpCommand.RELP_OPEN:
+		session.open = true
+		return session.respond(request.TransactionId, session.offer) == nil
+	case RelpCommand.RELP_SYSLOG:
+		if !session.open {
+			return session.respond(request.TransactionId, []byte("500 session not open")) == nil
+		}
+		handlerErr := session.handler(request.Data)
+		if handlerErr != nil {
+			// line breaks would start new data lines of the response
+			reason := strings.NewReplacer("\r\n", " ", "\n", " ", "\r", " ").Replace(handlerErr.Error())
+			return session.respond(request.TransactionId, []byte("500 "+reason)) == nil
+		}
+		return session.respond(request.TransactionId, []byte("200 OK")) == nil
+	case RelpCommand.RELP_CLOSE:
+		// acknowledge the close and notify the client that the server closes its side too
+		if session.respond(request.TransactionId, nil) != nil {
+			return false
+		}
+		session.ServerClose()
+		return false
+	case RelpCommand.RELP_ABORT:
+		return false
+	default:
+		return session.respond(request.TransactionId, []byte("500 unknown command")) == nil
+	}
+}
+
+// respond writes the rsp frame with the given data for the transaction
+func (session *RelpSession) respond(txnId uint64, data []byte) error {
+	return session.write(&RelpFrame.TX{Frame: RelpFrame.Frame{
+		TransactionId: txnId,
+		Cmd:           RelpCommand.RELP_RSP,
+		DataLength:    len(data),
+		Data:          data,
+	}})
+}
+
+// write writes the frame to the connection
+func (session *RelpSession) write(tx *RelpFrame.TX) error {
+	session.txMutex.Lock()
+	defer session.txMutex.Unlock()
+	session.txBuffer.Reset()
+	_, err := tx.Write(session.txBuffer)
+	if err != nil {
+		return err
+	}
+	_, err = session.connection.Write(session.txBuffer.Bytes())
+	if err != nil {
+		session.logger.Warn("RelpSession> Error writing response", "error", err)
+	}
+	return err
+}
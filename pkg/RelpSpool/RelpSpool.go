@@ -0,0 +1,475 @@
+package RelpSpool
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/teragrep/rlp_05/internal/RelpCommand"
+	"github.com/teragrep/rlp_05/internal/RelpLog"
+	"github.com/teragrep/rlp_05/pkg/RelpBatch"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// INDEX_FILE is the name of the file containing the acknowledged sequence number ranges
+const INDEX_FILE = "acknowledged.idx"
+
+// ackRange is an inclusive range of acknowledged sequence numbers
+type ackRange struct {
+	from uint64
+	to   uint64
+}
+
+// RelpSpool is an on-disk spool for syslog messages which have not been acknowledged by the server yet.
+// Messages are appended to segment files in the Directory, and the acknowledged sequence number ranges are
+// appended to an index file. Segments whose every message has been acknowledged are deleted.
+// After a restart, the messages that were never acknowledged can be replayed into a batch.
+// Replaying continues from the first message that was not acknowledged when the spool was last replayed.
+type RelpSpool struct {
+	Directory      string
+	MaxSegmentSize int64
+	Logger         *slog.Logger
+	segments       []*spoolSegment
+	acknowledged   []ackRange
+	index          *os.File
+	nextSeq        uint64
+	replaySegment  *spoolSegment
+	replayOffset   int64
+	mutex          sync.Mutex
+}
+
+// Init creates the Directory if needed, and loads the existing segments and index from it.
+// Records left partially written by a crash are truncated.
+func (spool *RelpSpool) Init() error {
+	spool.mutex.Lock()
+	defer spool.mutex.Unlock()
+	if spool.MaxSegmentSize <= 0 {
+		spool.MaxSegmentSize = 64 * 1024 * 1024
+	}
+	spool.segments = nil
+	spool.acknowledged = nil
+	spool.nextSeq = 1
+	spool.replaySegment = nil
+	spool.replayOffset = 0
+
+	err := os.MkdirAll(spool.Directory, 0o700)
+	if err != nil {
+		return err
+	}
+	err = spool.loadSegments()
+	if err != nil {
+		return err
+	}
+	err = spool.loadIndex()
+	if err != nil {
+		return err
+	}
+
+	for _, r := range spool.acknowledged {
+		if r.to >= spool.nextSeq {
+			spool.nextSeq = r.to + 1
+		}
+	}
+
+	// continue appending to the last segment, which also truncates a partially written record
+	if len(spool.segments) > 0 {
+		last := spool.segments[len(spool.segments)-1]
+		err = last.openForAppend()
+		if err != nil {
+			return err
+		}
+	}
+
+	return spool.compact()
+}
+
+// Append writes the payloads to the spool and syncs them to disk. Returns the sequence numbers of the payloads.
+func (spool *RelpSpool) Append(payloads ...[]byte) ([]uint64, error) {
+	spool.mutex.Lock()
+	defer spool.mutex.Unlock()
+	if len(payloads) == 0 {
+		return nil, nil
+	}
+
+	segment, err := spool.currentSegment()
+	if err != nil {
+		return nil, err
+	}
+	first := spool.nextSeq
+	err = segment.append(first, payloads)
+	if err != nil {
+		return nil, err
+	}
+	spool.nextSeq += uint64(len(payloads))
+
+	seqs := make([]uint64, len(payloads))
+	for i := range payloads {
+		seqs[i] = first + uint64(i)
+	}
+	return seqs, nil
+}
+
+// Acknowledge marks the sequence numbers as delivered in the index, and deletes the segments
+// whose every message has been acknowledged. Sequence numbers already acknowledged are skipped.
+func (spool *RelpSpool) Acknowledge(seqs ...uint64) error {
+	spool.mutex.Lock()
+	defer spool.mutex.Unlock()
+	var sorted []uint64
+	for _, seq := range seqs {
+		if spool.isPending(seq) {
+			sorted = append(sorted, seq)
+		}
+	}
+	if len(sorted) == 0 {
+		return nil
+	}
+
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	var ranges []ackRange
+	for _, seq := range sorted {
+		if len(ranges) > 0 && seq <= ranges[len(ranges)-1].to+1 {
+			if seq > ranges[len(ranges)-1].to {
+				ranges[len(ranges)-1].to = seq
+			}
+		} else {
+			ranges = append(ranges, ackRange{from: seq, to: seq})
+		}
+	}
+
+	writer := bufio.NewWriter(spool.index)
+	for _, r := range ranges {
+		_, err := fmt.Fprintf(writer, "%d %d\n", r.from, r.to)
+		if err != nil {
+			return err
+		}
+	}
+	err := writer.Flush()
+	if err != nil {
+		return err
+	}
+	err = spool.index.Sync()
+	if err != nil {
+		return err
+	}
+
+	spool.acknowledged = mergeRanges(append(spool.acknowledged, ranges...))
+	return spool.compact()
+}
+
+// Replay inserts all the messages which have not been acknowledged into the batch, in the order they were
+// appended. The inserted requests are acknowledged in the spool once the batch is verified with AcknowledgeBatch.
+// Returns the amount of replayed messages.
+func (spool *RelpSpool) Replay(batch *RelpBatch.RelpBatch) (int, error) {
+	return spool.ReplayLimit(batch, 0)
+}
+
+// ReplayLimit works like Replay, but inserts at most limit messages into the batch. Zero limit means no limit.
+func (spool *RelpSpool) ReplayLimit(batch *RelpBatch.RelpBatch, limit int) (int, error) {
+	spool.mutex.Lock()
+	defer spool.mutex.Unlock()
+	start, offset := 0, int64(0)
+	for i, segment := range spool.segments {
+		if segment == spool.replaySegment {
+			start, offset = i, spool.replayOffset
+		}
+	}
+
+	// the cursor is moved past the acknowledged messages until the first one that is replayed
+	replayed := 0
+	advancing := true
+	for i := start; i < len(spool.segments); i++ {
+		if limit > 0 && replayed >= limit {
+			break
+		}
+		segment := spool.segments[i]
+		if i > start {
+			offset = 0
+		}
+		_, err := segment.scan(offset, func(seq uint64, payload []byte, next int64) bool {
+			if spool.isAcknowledged(seq) {
+				if advancing {
+					spool.replaySegment, spool.replayOffset = segment, next
+				}
+				return true
+			}
+			advancing = false
+			id := batch.Insert(payload)
+			batch.PutSpoolId(id, seq)
+			replayed++
+			return limit <= 0 || replayed < limit
+		})
+		if err != nil {
+			return replayed, err
+		}
+		if advancing && i+1 < len(spool.segments) {
+			spool.replaySegment, spool.replayOffset = spool.segments[i+1], 0
+		}
+	}
+	RelpLog.OrDefault(spool.Logger).Debug("RelpSpool> Replayed messages", "count", replayed)
+	return replayed, nil
+}
+
+// SpoolBatch appends the syslog requests of the batch that have not been spooled yet to the spool
+func (spool *RelpSpool) SpoolBatch(batch *RelpBatch.RelpBatch) error {
+	var ids []uint64
+	var payloads [][]byte
+	for id := uint64(1); id <= batch.RequestId; id++ {
+		request, err := batch.GetRequest(id)
+		if err != nil || request.Cmd != RelpCommand.RELP_SYSLOG {
+			continue
+		}
+		if _, isSpooled := batch.GetSpoolId(id); isSpooled {
+			continue
+		}
+		ids = append(ids, id)
+		payloads = append(payloads, request.Data)
+	}
+
+	seqs, err := spool.Append(payloads...)
+	if err != nil {
+		return err
+	}
+	for i, id := range ids {
+		batch.PutSpoolId(id, seqs[i])
+	}
+	return nil
+}
+
+// AcknowledgeBatch acknowledges the spooled requests of the batch which got a response from the server,
+// whether accepted or rejected. Only the requests left without a response are replayed later.
+func (spool *RelpSpool) AcknowledgeBatch(batch *RelpBatch.RelpBatch) error {
+	var seqs []uint64
+	for id := uint64(1); id <= batch.RequestId; id++ {
+		seq, isSpooled := batch.GetSpoolId(id)
+		if _, err := batch.GetResponse(id); isSpooled && err == nil {
+			seqs = append(seqs, seq)
+		}
+	}
+	return spool.Acknowledge(seqs...)
+}
+
+// Close closes the segment and index files
+func (spool *RelpSpool) Close() error {
+	spool.mutex.Lock()
+	defer spool.mutex.Unlock()
+	var err error
+	for _, segment := range spool.segments {
+		closeErr := segment.close()
+		if closeErr != nil {
+			err = closeErr
+		}
+	}
+	if spool.index != nil {
+		closeErr := spool.index.Close()
+		if closeErr != nil {
+			err = closeErr
+		}
+		spool.index = nil
+	}
+	return err
+}
+
+// loadSegments finds the segment files of the Directory and scans them for their sequence numbers
+func (spool *RelpSpool) loadSegments() error {
+	paths, err := filepath.Glob(filepath.Join(spool.Directory, "segment-*.log"))
+	if err != nil {
+		return err
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		name := strings.TrimSuffix(strings.TrimPrefix(filepath.Base(path), "segment-"), ".log")
+		firstSeq, parseErr := strconv.ParseUint(name, 10, 64)
+		if parseErr != nil {
+			continue
+		}
+		segment := &spoolSegment{path: path, firstSeq: firstSeq, lastSeq: firstSeq - 1}
+		size, scanErr := segment.scan(0, func(seq uint64, _ []byte, _ int64) bool {
+			segment.lastSeq = seq
+			return true
+		})
+		if scanErr != nil {
+			return scanErr
+		}
+		segment.size = size
+		spool.segments = append(spool.segments, segment)
+		spool.nextSeq = segment.lastSeq + 1
+	}
+	return nil
+}
+
+// loadIndex reads the acknowledged ranges from the index file, and opens it for appending.
+// A partially written last line is ignored.
+func (spool *RelpSpool) loadIndex() error {
+	path := filepath.Join(spool.Directory, INDEX_FILE)
+	content, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for _, line := range strings.Split(string(content), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			continue
+		}
+		from, fromErr := strconv.ParseUint(fields[0], 10, 64)
+		to, toErr := strconv.ParseUint(fields[1], 10, 64)
+		if fromErr != nil || toErr != nil || from > to {
+			continue
+		}
+		spool.acknowledged = append(spool.acknowledged, ackRange{from: from, to: to})
+	}
+	spool.acknowledged = mergeRanges(spool.acknowledged)
+
+	// rewrite the index so that a partially written last line is not continued by the next append
+	return spool.rewriteIndex()
+}
+
+// rewriteIndex replaces the index file with the current acknowledged ranges, and opens it for appending
+func (spool *RelpSpool) rewriteIndex() error {
+	if spool.index != nil {
+		_ = spool.index.Close()
+		spool.index = nil
+	}
+	path := filepath.Join(spool.Directory, INDEX_FILE)
+	tmpPath := path + ".tmp"
+	tmp, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	writer := bufio.NewWriter(tmp)
+	for _, r := range spool.acknowledged {
+		_, err = fmt.Fprintf(writer, "%d %d\n", r.from, r.to)
+		if err != nil {
+			tmp.Close()
+			return err
+		}
+	}
+	err = writer.Flush()
+	if err == nil {
+		err = tmp.Sync()
+	}
+	closeErr := tmp.Close()
+	if err != nil {
+		return err
+	}
+	if closeErr != nil {
+		return closeErr
+	}
+	err = os.Rename(tmpPath, path)
+	if err != nil {
+		return err
+	}
+
+	spool.index, err = os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0o600)
+	return err
+}
+
+// currentSegment returns the segment to append to, starting a new segment if the last one is full
+func (spool *RelpSpool) currentSegment() (*spoolSegment, error) {
+	if len(spool.segments) > 0 {
+		last := spool.segments[len(spool.segments)-1]
+		if last.size < spool.MaxSegmentSize {
+			return last, nil
+		}
+		err := last.close()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	segment := &spoolSegment{
+		path:     segmentPath(spool.Directory, spool.nextSeq),
+		firstSeq: spool.nextSeq,
+		lastSeq:  spool.nextSeq - 1,
+	}
+	err := segment.openForAppend()
+	if err != nil {
+		return nil, err
+	}
+	spool.segments = append(spool.segments, segment)
+	return segment, nil
+}
+
+// compact deletes the segments, other than the last one, whose every message has been acknowledged.
+// The index is rewritten without the ranges of the deleted segments.
+func (spool *RelpSpool) compact() error {
+	deleted := 0
+	for len(spool.segments) > 1 {
+		segment := spool.segments[0]
+		if !segment.isEmpty() && !spool.isAcknowledgedRange(segment.firstSeq, segment.lastSeq) {
+			break
+		}
+		_ = segment.close()
+		err := os.Remove(segment.path)
+		if err != nil {
+			return err
+		}
+		RelpLog.OrDefault(spool.Logger).Debug("RelpSpool> Deleted acknowledged segment", "path", segment.path)
+		if segment == spool.replaySegment {
+			spool.replaySegment, spool.replayOffset = nil, 0
+		}
+		spool.segments = spool.segments[1:]
+		deleted++
+	}
+	if deleted == 0 {
+		return nil
+	}
+
+	// ranges below the first remaining segment are not needed anymore
+	minSeq := spool.segments[0].firstSeq
+	var remaining []ackRange
+	for _, r := range spool.acknowledged {
+		if r.to < minSeq {
+			continue
+		}
+		if r.from < minSeq {
+			r.from = minSeq
+		}
+		remaining = append(remaining, r)
+	}
+	spool.acknowledged = remaining
+	return spool.rewriteIndex()
+}
+
+// isPending returns true if the sequence number has been appended and not acknowledged yet.
+// The ranges of deleted segments are dropped from the index, so sequence numbers below the first segment
+// are acknowledged as well.
+func (spool *RelpSpool) isPending(seq uint64) bool {
+	if seq == 0 || seq >= spool.nextSeq {
+		return false
+	}
+	if len(spool.segments) > 0 && seq < spool.segments[0].firstSeq {
+		return false
+	}
+	return !spool.isAcknowledged(seq)
+}
+
+// isAcknowledged returns true if the sequence number is within an acknowledged range
+func (spool *RelpSpool) isAcknowledged(seq uint64) bool {
+	return spool.isAcknowledgedRange(seq, seq)
+}
+
+// isAcknowledgedRange returns true if the whole range is within a single acknowledged range
+func (spool *RelpSpool) isAcknowledgedRange(from, to uint64) bool {
+	i := sort.Search(len(spool.acknowledged), func(i int) bool { return spool.acknowledged[i].to >= from })
+	return i < len(spool.acknowledged) && spool.acknowledged[i].from <= from && spool.acknowledged[i].to >= to
+}
+
+// mergeRanges sorts the ranges and merges overlapping and adjacent ones
+func mergeRanges(ranges []ackRange) []ackRange {
+	sort.Slice(ranges, func(i, j int) bool { return ranges[i].from < ranges[j].from })
+	var merged []ackRange
+	for _, r := range ranges {
+		if len(merged) > 0 && r.from <= merged[len(merged)-1].to+1 {
+			if r.to > merged[len(merged)-1].to {
+				merged[len(merged)-1].to = r.to
+			}
+		} else {
+			merged = append(merged, r)
+		}
+	}
+	return merged
+}
@@ -0,0 +1,179 @@
+package RelpSpool
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// record header is seq (8 bytes) and payload length (4 bytes), followed by the payload and crc32 (4 bytes)
+const (
+	RECORD_HEADER_LEN  = 12
+	RECORD_TRAILER_LEN = 4
+)
+
+// errChecksumMismatch ends the scan of a segment at a record that was not completely written
+var errChecksumMismatch = errors.New("checksum mismatch")
+
+// spoolSegment is a single append-only segment file. The records of a segment have consecutive
+// sequence numbers from firstSeq to lastSeq.
+type spoolSegment struct {
+	path     string
+	firstSeq uint64
+	lastSeq  uint64
+	size     int64
+	file     *os.File
+}
+
+// segmentPath returns the path of the segment starting from the sequence number
+func segmentPath(directory string, firstSeq uint64) string {
+	return filepath.Join(directory, fmt.Sprintf("segment-%020d.log", firstSeq))
+}
+
+// isEmpty returns true if no records have been written to the segment
+func (segment *spoolSegment) isEmpty() bool {
+	return segment.lastSeq < segment.firstSeq
+}
+
+// scan reads the records of the segment starting from the offset, calling the function for each with the offset
+// of the next record, until the function returns false. A record that was only partially written, e.g. because
+// of a crash, ends the scan, and its offset is returned as the valid size of the segment.
+func (segment *spoolSegment) scan(offset int64, fn func(seq uint64, payload []byte, next int64) bool) (int64, error) {
+	file, err := os.Open(segment.path)
+	if err != nil {
+		return offset, err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return offset, err
+	}
+	if _, err = file.Seek(offset, io.SeekStart); err != nil {
+		return offset, err
+	}
+
+	reader := bufio.NewReader(file)
+	header := make([]byte, RECORD_HEADER_LEN)
+	trailer := make([]byte, RECORD_TRAILER_LEN)
+	for {
+		if _, err = io.ReadFull(reader, header); err != nil {
+			break
+		}
+		seq := binary.BigEndian.Uint64(header[0:8])
+		length := int64(binary.BigEndian.Uint32(header[8:12]))
+		// a corrupted length is not allocated for, as the record can't extend past the end of the file
+		if length > info.Size()-offset-RECORD_HEADER_LEN-RECORD_TRAILER_LEN {
+			err = io.ErrUnexpectedEOF
+			break
+		}
+		payload := make([]byte, length)
+		if _, err = io.ReadFull(reader, payload); err != nil {
+			break
+		}
+		if _, err = io.ReadFull(reader, trailer); err != nil {
+			break
+		}
+		checksum := crc32.NewIEEE()
+		checksum.Write(header)
+		checksum.Write(payload)
+		if checksum.Sum32() != binary.BigEndian.Uint32(trailer) {
+			err = errChecksumMismatch
+			break
+		}
+
+		offset += int64(RECORD_HEADER_LEN + len(payload) + RECORD_TRAILER_LEN)
+		if !fn(seq, payload, offset) {
+			return offset, nil
+		}
+	}
+
+	if err == io.EOF || err == io.ErrUnexpectedEOF || err == errChecksumMismatch {
+		return offset, nil
+	}
+	return offset, err
+}
+
+// openForAppend opens the segment file for appending, truncating it to its valid size
+func (segment *spoolSegment) openForAppend() error {
+	file, err := os.OpenFile(segment.path, os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	if err = file.Truncate(segment.size); err != nil {
+		file.Close()
+		return err
+	}
+	if _, err = file.Seek(segment.size, io.SeekStart); err != nil {
+		file.Close()
+		return err
+	}
+	segment.file = file
+	return nil
+}
+
+// append writes the records with consecutive sequence numbers starting from seq, and syncs them to disk.
+// If writing fails, the partially written records are truncated away.
+func (segment *spoolSegment) append(seq uint64, payloads [][]byte) error {
+	err := segment.write(seq, payloads)
+	if err != nil {
+		_ = segment.file.Truncate(segment.size)
+		_, _ = segment.file.Seek(segment.size, io.SeekStart)
+		return err
+	}
+	segment.size += segment.recordsSize(payloads)
+	segment.lastSeq = seq + uint64(len(payloads)) - 1
+	return nil
+}
+
+// recordsSize returns the amount of bytes the payloads take as records
+func (segment *spoolSegment) recordsSize(payloads [][]byte) int64 {
+	var size int64 = 0
+	for _, payload := range payloads {
+		size += int64(RECORD_HEADER_LEN + len(payload) + RECORD_TRAILER_LEN)
+	}
+	return size
+}
+
+// write writes and syncs the records to the segment file
+func (segment *spoolSegment) write(seq uint64, payloads [][]byte) error {
+	writer := bufio.NewWriter(segment.file)
+	header := make([]byte, RECORD_HEADER_LEN)
+	trailer := make([]byte, RECORD_TRAILER_LEN)
+	for i, payload := range payloads {
+		binary.BigEndian.PutUint64(header[0:8], seq+uint64(i))
+		binary.BigEndian.PutUint32(header[8:12], uint32(len(payload)))
+		checksum := crc32.NewIEEE()
+		checksum.Write(header)
+		checksum.Write(payload)
+		binary.BigEndian.PutUint32(trailer, checksum.Sum32())
+
+		if _, err := writer.Write(header); err != nil {
+			return err
+		}
+		if _, err := writer.Write(payload); err != nil {
+			return err
+		}
+		if _, err := writer.Write(trailer); err != nil {
+			return err
+		}
+	}
+	if err := writer.Flush(); err != nil {
+		return err
+	}
+	return segment.file.Sync()
+}
+
+// close closes the segment file, if it is open
+func (segment *spoolSegment) close() error {
+	if segment.file == nil {
+		return nil
+	}
+	err := segment.file.Close()
+	segment.file = nil
+	return err
+}
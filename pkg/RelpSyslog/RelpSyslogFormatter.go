@@ -0,0 +1,9 @@
+package RelpSyslog
+
+import "github.com/teragrep/rlp_05/pkg/RelpBatch"
+
+// Formatter formats syslog messages into the payloads of RELP syslog requests
+type Formatter interface {
+	Format(msg *Message) ([]byte, error)
+	Insert(batch *RelpBatch.RelpBatch, msg *Message) (uint64, error)
+}
@@ -0,0 +1,79 @@
+package RelpSyslog
+
+import "time"
+
+// constants for the syslog facility codes (FACILITY_ prefix)
+const (
+	FACILITY_KERN     = 0
+	FACILITY_USER     = 1
+	FACILITY_MAIL     = 2
+	FACILITY_DAEMON   = 3
+	FACILITY_AUTH     = 4
+	FACILITY_SYSLOG   = 5
+	FACILITY_LPR      = 6
+	FACILITY_NEWS     = 7
+	FACILITY_UUCP     = 8
+	FACILITY_CRON     = 9
+	FACILITY_AUTHPRIV = 10
+	FACILITY_FTP      = 11
+	FACILITY_NTP      = 12
+	FACILITY_AUDIT    = 13
+	FACILITY_ALERT    = 14
+	FACILITY_CLOCK    = 15
+	FACILITY_LOCAL0   = 16
+	FACILITY_LOCAL1   = 17
+	FACILITY_LOCAL2   = 18
+	FACILITY_LOCAL3   = 19
+	FACILITY_LOCAL4   = 20
+	FACILITY_LOCAL5   = 21
+	FACILITY_LOCAL6   = 22
+	FACILITY_LOCAL7   = 23
+)
+
+// constants for the syslog severity codes (SEVERITY_ prefix)
+const (
+	SEVERITY_EMERGENCY = 0
+	SEVERITY_ALERT     = 1
+	SEVERITY_CRITICAL  = 2
+	SEVERITY_ERROR     = 3
+	SEVERITY_WARNING   = 4
+	SEVERITY_NOTICE    = 5
+	SEVERITY_INFO      = 6
+	SEVERITY_DEBUG     = 7
+)
+
+// SDParam is a single PARAM-NAME="PARAM-VALUE" pair of a structured data element
+type SDParam struct {
+	Name  string
+	Value string
+}
+
+// SDElement is a structured data element [SD-ID PARAM...]
+type SDElement struct {
+	Id     string
+	Params []SDParam
+}
+
+// Message contains the fields of a syslog message. Empty header fields and a zero Timestamp
+// are formatted as the NILVALUE "-".
+type Message struct {
+	Facility       int
+	Severity       int
+	Timestamp      time.Time
+	Hostname       string
+	AppName        string
+	ProcId         string
+	MsgId          string
+	StructuredData []SDElement
+	Msg            string
+}
+
+// Priority returns the PRI value of the message, calculated from the facility and severity
+func (msg *Message) Priority() int {
+	return msg.Facility*8 + msg.Severity
+}
+
+// AddSDElement appends a structured data element with the given id and parameters to the message
+func (msg *Message) AddSDElement(id string, params ...SDParam) {
+	msg.StructuredData = append(msg.StructuredData, SDElement{Id: id, Params: params})
+}
@@ -0,0 +1,118 @@
+package RelpSyslog
+
+import (
+	"github.com/teragrep/rlp_05/pkg/Errors"
+	"github.com/teragrep/rlp_05/pkg/RelpBatch"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Rfc3164Formatter formats messages as RFC 3164 (BSD) syslog messages;
+// <PRI>Mmm dd hh:mm:ss HOSTNAME TAG[PID]: MSG
+// The Hostname and Tag are used when the message's Hostname and AppName are empty.
+// The timestamp is written in the time zone of Location, and the current time is used if it is unset.
+// The TAG is truncated to MaxTagLength and the whole message to MaxLength bytes, zero disables truncation.
+// RFC 3164 has no structured data nor message id, so those fields are left out.
+type Rfc3164Formatter struct {
+	Hostname     string
+	Tag          string
+	Location     *time.Location
+	MaxTagLength int
+	MaxLength    int
+}
+
+// Init initializes the formatter with the hostname of the machine, the local time zone
+// and the limits of 32 characters for the TAG and 1024 bytes for the message
+func (formatter *Rfc3164Formatter) Init() {
+	hostname, err := os.Hostname()
+	if err == nil {
+		formatter.Hostname = hostname
+	}
+	formatter.Location = time.Local
+	formatter.MaxTagLength = 32
+	formatter.MaxLength = 1024
+}
+
+// Format returns the message in the RFC 3164 format, or a SyslogFormatError if any of the fields
+// can't be represented in it
+func (formatter *Rfc3164Formatter) Format(msg *Message) ([]byte, error) {
+	if err := validatePriority(msg); err != nil {
+		return nil, err
+	}
+	hostname := msg.Hostname
+	if hostname == "" {
+		hostname = formatter.Hostname
+	}
+	if hostname == "" {
+		return nil, &Errors.SyslogFormatError{Field: "HOSTNAME", Reason: "is required"}
+	}
+	if err := validateHeaderField("HOSTNAME", hostname, 255); err != nil {
+		return nil, err
+	}
+	tag := msg.AppName
+	if tag == "" {
+		tag = formatter.Tag
+	}
+	if err := validatePrintable("TAG", tag); err != nil {
+		return nil, err
+	}
+	if formatter.MaxTagLength > 0 && len(tag) > formatter.MaxTagLength {
+		tag = tag[:formatter.MaxTagLength]
+	}
+	if err := validateHeaderField("PROCID", msg.ProcId, 128); err != nil {
+		return nil, err
+	}
+
+	timestamp := msg.Timestamp
+	if timestamp.IsZero() {
+		timestamp = time.Now()
+	}
+	if formatter.Location != nil {
+		timestamp = timestamp.In(formatter.Location)
+	}
+
+	buf := strings.Builder{}
+	buf.WriteString("<")
+	buf.WriteString(strconv.Itoa(msg.Priority()))
+	buf.WriteString(">")
+	buf.WriteString(timestamp.Format(time.Stamp))
+	buf.WriteString(" ")
+	buf.WriteString(hostname)
+	buf.WriteString(" ")
+	if tag != "" {
+		buf.WriteString(tag)
+		if msg.ProcId != "" {
+			buf.WriteString("[")
+			buf.WriteString(msg.ProcId)
+			buf.WriteString("]")
+		}
+		buf.WriteString(": ")
+	}
+	buf.WriteString(msg.Msg)
+
+	formatted := buf.String()
+	if formatter.MaxLength > 0 && len(formatted) > formatter.MaxLength {
+		formatted = formatted[:formatter.MaxLength]
+		// don't leave a partial UTF-8 sequence at the end
+		for {
+			r, size := utf8.DecodeLastRuneInString(formatted)
+			if r != utf8.RuneError || size != 1 {
+				break
+			}
+			formatted = formatted[:len(formatted)-1]
+		}
+	}
+	return []byte(formatted), nil
+}
+
+// Insert formats the message and inserts it to the batch, returning the id of the request
+func (formatter *Rfc3164Formatter) Insert(batch *RelpBatch.RelpBatch, msg *Message) (uint64, error) {
+	syslogMsg, err := formatter.Format(msg)
+	if err != nil {
+		return 0, err
+	}
+	return batch.Insert(syslogMsg), nil
+}
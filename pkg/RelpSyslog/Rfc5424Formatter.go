@@ -0,0 +1,147 @@
+package RelpSyslog
+
+import (
+	"fmt"
+	"github.com/teragrep/rlp_05/pkg/Errors"
+	"github.com/teragrep/rlp_05/pkg/RelpBatch"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Rfc5424Formatter formats messages as RFC 5424 syslog messages;
+// <PRI>1 TIMESTAMP HOSTNAME APP-NAME PROCID MSGID STRUCTURED-DATA [BOM MSG]
+type Rfc5424Formatter struct {
+}
+
+// Format returns the message in the RFC 5424 format, or a SyslogFormatError if any of the fields
+// can't be represented in it
+func (formatter *Rfc5424Formatter) Format(msg *Message) ([]byte, error) {
+	if err := validatePriority(msg); err != nil {
+		return nil, err
+	}
+
+	buf := strings.Builder{}
+	buf.WriteString("<")
+	buf.WriteString(strconv.Itoa(msg.Priority()))
+	buf.WriteString(">1 ")
+	if msg.Timestamp.IsZero() {
+		buf.WriteString("-")
+	} else {
+		// TIME-SECFRAC allows at most six digits
+		buf.WriteString(msg.Timestamp.Format("2006-01-02T15:04:05.000000Z07:00"))
+	}
+
+	headerFields := []struct {
+		name   string
+		value  string
+		maxLen int
+	}{
+		{"HOSTNAME", msg.Hostname, 255},
+		{"APP-NAME", msg.AppName, 48},
+		{"PROCID", msg.ProcId, 128},
+		{"MSGID", msg.MsgId, 32},
+	}
+	for _, field := range headerFields {
+		if err := validateHeaderField(field.name, field.value, field.maxLen); err != nil {
+			return nil, err
+		}
+		buf.WriteString(" ")
+		if field.value == "" {
+			buf.WriteString("-")
+		} else {
+			buf.WriteString(field.value)
+		}
+	}
+
+	buf.WriteString(" ")
+	if len(msg.StructuredData) == 0 {
+		buf.WriteString("-")
+	}
+	for _, element := range msg.StructuredData {
+		if err := validateSDName("SD-ID", element.Id); err != nil {
+			return nil, err
+		}
+		buf.WriteString("[")
+		buf.WriteString(element.Id)
+		for _, param := range element.Params {
+			if err := validateSDName("PARAM-NAME", param.Name); err != nil {
+				return nil, err
+			}
+			if !utf8.ValidString(param.Value) {
+				return nil, &Errors.SyslogFormatError{Field: "PARAM-VALUE", Reason: "not valid UTF-8"}
+			}
+			buf.WriteString(" ")
+			buf.WriteString(param.Name)
+			buf.WriteString("=\"")
+			buf.WriteString(escapeParamValue(param.Value))
+			buf.WriteString("\"")
+		}
+		buf.WriteString("]")
+	}
+
+	if msg.Msg != "" {
+		if !utf8.ValidString(msg.Msg) {
+			return nil, &Errors.SyslogFormatError{Field: "MSG", Reason: "not valid UTF-8"}
+		}
+		buf.WriteString(" \xef\xbb\xbf")
+		buf.WriteString(msg.Msg)
+	}
+	return []byte(buf.String()), nil
+}
+
+// Insert formats the message and inserts it to the batch, returning the id of the request
+func (formatter *Rfc5424Formatter) Insert(batch *RelpBatch.RelpBatch, msg *Message) (uint64, error) {
+	syslogMsg, err := formatter.Format(msg)
+	if err != nil {
+		return 0, err
+	}
+	return batch.Insert(syslogMsg), nil
+}
+
+// validatePriority checks that the facility and severity are within their ranges
+func validatePriority(msg *Message) error {
+	if msg.Facility < FACILITY_KERN || msg.Facility > FACILITY_LOCAL7 {
+		return &Errors.SyslogFormatError{Field: "FACILITY", Reason: fmt.Sprintf("%v is not within 0-23", msg.Facility)}
+	}
+	if msg.Severity < SEVERITY_EMERGENCY || msg.Severity > SEVERITY_DEBUG {
+		return &Errors.SyslogFormatError{Field: "SEVERITY", Reason: fmt.Sprintf("%v is not within 0-7", msg.Severity)}
+	}
+	return nil
+}
+
+// validateHeaderField checks that the header field consists of at most maxLen printable US-ASCII characters
+func validateHeaderField(name string, value string, maxLen int) error {
+	if len(value) > maxLen {
+		return &Errors.SyslogFormatError{Field: name, Reason: fmt.Sprintf("longer than %v characters", maxLen)}
+	}
+	return validatePrintable(name, value)
+}
+
+// validatePrintable checks that the field consists of printable US-ASCII characters
+func validatePrintable(name string, value string) error {
+	for i := 0; i < len(value); i++ {
+		if value[i] < 33 || value[i] > 126 {
+			return &Errors.SyslogFormatError{Field: name, Reason: "contains a character which is not printable US-ASCII"}
+		}
+	}
+	return nil
+}
+
+// validateSDName checks that the SD-NAME is 1-32 printable US-ASCII characters, excluding '=', SP, ']' and '"'
+func validateSDName(name string, value string) error {
+	if value == "" || len(value) > 32 {
+		return &Errors.SyslogFormatError{Field: name, Reason: "length is not within 1-32 characters"}
+	}
+	for i := 0; i < len(value); i++ {
+		if value[i] < 33 || value[i] > 126 || strings.IndexByte("= ]\"", value[i]) >= 0 {
+			return &Errors.SyslogFormatError{Field: name, Reason: fmt.Sprintf("contains an invalid character %q", value[i])}
+		}
+	}
+	return nil
+}
+
+// escapeParamValue escapes the characters '"', '\' and ']' with a backslash
+func escapeParamValue(value string) string {
+	return strings.NewReplacer(`"`, `\"`, `\`, `\\`, `]`, `\]`).Replace(value)
+}
@@ -0,0 +1,301 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/teragrep/rlp_05/pkg/Errors"
+	"github.com/teragrep/rlp_05/pkg/RelpAdapter"
+	"github.com/teragrep/rlp_05/pkg/RelpBatch"
+	"github.com/teragrep/rlp_05/pkg/RelpConnection"
+	"github.com/teragrep/rlp_05/pkg/RelpDialer"
+	"github.com/teragrep/rlp_05/pkg/RelpServer"
+	"github.com/teragrep/rlp_05/pkg/RelpSpool"
+	"log/slog"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// serverOptions are the options of collectingServer. Connect also starts listening.
+type serverOptions struct {
+	listen  bool
+	connect bool
+	release chan struct{}
+}
+
+// collectingServer initializes a RelpServer saving the received payloads, listening on 127.0.0.1 and returning
+// a connection connected to it if set in the options. If release is set, the server does not answer before
+// it is closed.
+func collectingServer(t *testing.T, options serverOptions) (*RelpServer.RelpServer, *RelpConnection.RelpConnection,
+	func() []string) {
+	var mutex sync.Mutex
+	var received []string
+	relpServer := &RelpServer.RelpServer{Handler: func(payload []byte) error {
+		if options.release != nil {
+			<-options.release
+		}
+		mutex.Lock()
+		received = append(received, string(payload))
+		mutex.Unlock()
+		return nil
+	}}
+	relpServer.Init()
+	collected := func() []string {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return append([]string(nil), received...)
+	}
+	if !options.listen && !options.connect {
+		return relpServer, nil, collected
+	}
+
+	if err := relpServer.Listen("127.0.0.1", 0); err != nil {
+		t.Fatalf("Could not start server: %v", err)
+	}
+	if !options.connect {
+		return relpServer, nil, collected
+	}
+	sess := &RelpConnection.RelpConnection{RelpDialer: &RelpDialer.RelpPlainDialer{}}
+	sess.Init()
+	ok, _ := sess.Connect("127.0.0.1", relpServer.Addr().(*net.TCPAddr).Port)
+	if !ok {
+		t.Fatalf("Connection was not successful! (success=%v); want true", ok)
+	}
+	return relpServer, sess, collected
+}
+
+// TestWriterSplitsLines: Writes three lines, the last one without a newline, using RelpWriter with SplitLines.
+// Checks that each line was received as its own message once the writer was closed.
+func TestWriterSplitsLines(t *testing.T) {
+	relpServer, sess, received := collectingServer(t, serverOptions{connect: true})
+	defer relpServer.Close()
+	batcher := &RelpAdapter.RelpBatcher{Connection: sess}
+	batcher.Init()
+	writer := &RelpAdapter.RelpWriter{Batcher: batcher, SplitLines: true}
+
+	_, _ = writer.Write([]byte("first\nsec"))
+	_, _ = writer.Write([]byte("ond\nthird"))
+	err := writer.Close()
+	sess.Disconnect()
+
+	if err != nil || strings.Join(received(), ",") != "first,second,third" {
+		t.Errorf("Received %v with error %v; want [first second third] and nil", received(), err)
+	}
+}
+
+// TestBatcherFlushesPeriodically: Adds a single message to a RelpBatcher with a short FlushInterval.
+// Checks that the message is received without flushing explicitly.
+func TestBatcherFlushesPeriodically(t *testing.T) {
+	relpServer, sess, received := collectingServer(t, serverOptions{connect: true})
+	defer relpServer.Close()
+	batcher := &RelpAdapter.RelpBatcher{Connection: sess, FlushInterval: 10 * time.Millisecond}
+	batcher.Init()
+	defer batcher.Close()
+
+	_ = batcher.Add([]byte("HelloThisIsAMessage"))
+	deadline := time.Now().Add(5 * time.Second)
+	for len(received()) == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if len(received()) != 1 {
+		t.Errorf("Received %v message(s); want 1", len(received()))
+	}
+}
+
+// TestSlogHandlerFormatsRecords: Logs a record with attributes using RelpSlogHandler.
+// Checks that the message has the syslog header with the PRI of the level, and the attributes.
+func TestSlogHandlerFormatsRecords(t *testing.T) {
+	relpServer, sess, received := collectingServer(t, serverOptions{connect: true})
+	defer relpServer.Close()
+	batcher := &RelpAdapter.RelpBatcher{Connection: sess}
+	batcher.Init()
+	handler := &RelpAdapter.RelpSlogHandler{Batcher: batcher, Hostname: "host", AppName: "app"}
+	handler.Init()
+
+	logger := slog.New(handler).With("service", "test").WithGroup("request")
+	logger.Warn("HelloThisIsAMessage", "id", 1)
+	logger.Debug("filtered by level")
+	_ = batcher.Close()
+	sess.Disconnect()
+
+	messages := received()
+	if len(messages) != 1 || !strings.HasPrefix(messages[0], "<12>1 ") ||
+		!strings.Contains(messages[0], " host app ") ||
+		!strings.HasSuffix(messages[0], "HelloThisIsAMessage service=test request.id=1") {
+		t.Errorf("Received %v; want a single warning with the header and attributes", messages)
+	}
+}
+
+// TestProducerDropsNewest: Sends ten messages using RelpProducer with OVERFLOW_DROP_NEWEST and a queue of two,
+// while the server is not answering.
+// Checks that the messages not fitting in the queue were dropped and counted, and the accepted ones were received.
+func TestProducerDropsNewest(t *testing.T) {
+	release := make(chan struct{})
+	relpServer, sess, received := collectingServer(t, serverOptions{connect: true, release: release})
+	defer relpServer.Close()
+	producer := &RelpAdapter.RelpProducer{Connection: sess, QueueSize: 2, MaxBatchSize: 1,
+		Overflow: RelpAdapter.OVERFLOW_DROP_NEWEST}
+	if err := producer.Init(); err != nil {
+		t.Fatalf("Could not initialize producer: %v", err)
+	}
+
+	accepted := 0
+	for i := 0; i < 10; i++ {
+		err := producer.Send([]byte(fmt.Sprintf("HelloThisIsAMessage%v", i)))
+		var droppedErr *Errors.MessageDroppedError
+		if err == nil {
+			accepted++
+		} else if !errors.As(err, &droppedErr) {
+			t.Errorf("Send returned %v; want nil or MessageDroppedError", err)
+		}
+	}
+	close(release)
+	_ = producer.Close()
+	sess.Disconnect()
+
+	stats := producer.Stats()
+	if accepted > 3 || stats.Dropped != uint64(10-accepted) || len(received()) != accepted || stats.Sent != uint64(accepted) {
+		t.Errorf("Accepted %v, received %v with stats %+v; want at most 3 accepted, all of them sent and the rest dropped",
+			accepted, len(received()), stats)
+	}
+}
+
+// TestProducerSpillsInOrder: Sends ten messages using RelpProducer with OVERFLOW_SPILL and a queue of two,
+// while the server is not answering.
+// Checks that no message was dropped, that messages were spilled, and that all were received in order.
+func TestProducerSpillsInOrder(t *testing.T) {
+	release := make(chan struct{})
+	relpServer, sess, received := collectingServer(t, serverOptions{connect: true, release: release})
+	defer relpServer.Close()
+	spool := &RelpSpool.RelpSpool{Directory: t.TempDir()}
+	if err := spool.Init(); err != nil {
+		t.Fatalf("Could not initialize spool: %v", err)
+	}
+	defer spool.Close()
+	producer := &RelpAdapter.RelpProducer{Connection: sess, QueueSize: 2, MaxBatchSize: 1,
+		Overflow: RelpAdapter.OVERFLOW_SPILL, Spool: spool}
+	if err := producer.Init(); err != nil {
+		t.Fatalf("Could not initialize producer: %v", err)
+	}
+
+	var want []string
+	for i := 0; i < 10; i++ {
+		want = append(want, fmt.Sprintf("HelloThisIsAMessage%v", i))
+		if err := producer.Send([]byte(want[i])); err != nil {
+			t.Errorf("Send returned %v; want nil", err)
+		}
+	}
+	close(release)
+	_ = producer.Close()
+	sess.Disconnect()
+
+	stats := producer.Stats()
+	if stats.Dropped != 0 || stats.Spilled == 0 || strings.Join(received(), ",") != strings.Join(want, ",") {
+		t.Errorf("Received %v with stats %+v; want all messages in order, some spilled and none dropped", received(), stats)
+	}
+}
+
+// TestProducerBlocksWhenFull: Sends messages using RelpProducer with OVERFLOW_BLOCK and a queue of two,
+// while the server is not answering, until a send with a timeout does not fit in the queue.
+// Checks that the send timed out, that it was sent once the server answered, and that nothing was dropped.
+func TestProducerBlocksWhenFull(t *testing.T) {
+	release := make(chan struct{})
+	relpServer, sess, received := collectingServer(t, serverOptions{connect: true, release: release})
+	defer relpServer.Close()
+	producer := &RelpAdapter.RelpProducer{Connection: sess, QueueSize: 2, MaxBatchSize: 1,
+		Overflow: RelpAdapter.OVERFLOW_BLOCK}
+	if err := producer.Init(); err != nil {
+		t.Fatalf("Could not initialize producer: %v", err)
+	}
+
+	// one message is being committed and two are queued
+	var want []string
+	for i := 0; i < 3; i++ {
+		want = append(want, fmt.Sprintf("HelloThisIsAMessage%v", i))
+		_ = producer.Send([]byte(want[i]))
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	timeoutErr := producer.SendContext(ctx, []byte("HelloThisIsAMessage3"))
+	cancel()
+	close(release)
+	want = append(want, "HelloThisIsAMessage3")
+	sendErr := producer.Send([]byte(want[3]))
+	_ = producer.Close()
+	sess.Disconnect()
+
+	stats := producer.Stats()
+	if !errors.Is(timeoutErr, context.DeadlineExceeded) || sendErr != nil {
+		t.Errorf("Sends to the full queue returned %v and %v; want DeadlineExceeded and nil", timeoutErr, sendErr)
+	}
+	if stats.Dropped != 0 || strings.Join(received(), ",") != strings.Join(want, ",") {
+		t.Errorf("Received %v with stats %+v; want all messages in order and none dropped", received(), stats)
+	}
+}
+
+// TestProducerDropsOldest: Sends ten messages using RelpProducer with OVERFLOW_DROP_OLDEST and a queue of two,
+// while the server is not answering.
+// Checks that every send succeeded, that the last two messages were received after the ones being committed,
+// and that the others were dropped and counted.
+func TestProducerDropsOldest(t *testing.T) {
+	release := make(chan struct{})
+	relpServer, sess, received := collectingServer(t, serverOptions{connect: true, release: release})
+	defer relpServer.Close()
+	producer := &RelpAdapter.RelpProducer{Connection: sess, QueueSize: 2, MaxBatchSize: 1,
+		Overflow: RelpAdapter.OVERFLOW_DROP_OLDEST}
+	if err := producer.Init(); err != nil {
+		t.Fatalf("Could not initialize producer: %v", err)
+	}
+
+	for i := 0; i < 10; i++ {
+		if err := producer.Send([]byte(fmt.Sprintf("HelloThisIsAMessage%v", i))); err != nil {
+			t.Errorf("Send returned %v; want nil", err)
+		}
+	}
+	close(release)
+	_ = producer.Close()
+	sess.Disconnect()
+
+	stats := producer.Stats()
+	messages := received()
+	last := strings.Join(messages[max(len(messages)-2, 0):], ",")
+	if last != "HelloThisIsAMessage8,HelloThisIsAMessage9" || stats.Dropped != uint64(10-len(messages)) ||
+		stats.Sent != uint64(len(messages)) {
+		t.Errorf("Received %v with stats %+v; want the last two messages last, and the missing ones dropped",
+			messages, stats)
+	}
+}
+
+// TestProducerReplaysSpool: Appends two messages to a spool, as if left by a previous producer, and sends
+// a message using RelpProducer with OVERFLOW_DROP_NEWEST and the spool.
+// Checks that the spooled messages were received before the new one, and that the spool was drained.
+func TestProducerReplaysSpool(t *testing.T) {
+	relpServer, sess, received := collectingServer(t, serverOptions{connect: true})
+	defer relpServer.Close()
+	spool := &RelpSpool.RelpSpool{Directory: t.TempDir()}
+	if err := spool.Init(); err != nil {
+		t.Fatalf("Could not initialize spool: %v", err)
+	}
+	defer spool.Close()
+	_, _ = spool.Append([]byte("SpooledMessage0"), []byte("SpooledMessage1"))
+	producer := &RelpAdapter.RelpProducer{Connection: sess, Overflow: RelpAdapter.OVERFLOW_DROP_NEWEST, Spool: spool}
+	if err := producer.Init(); err != nil {
+		t.Fatalf("Could not initialize producer: %v", err)
+	}
+
+	sendErr := producer.Send([]byte("HelloThisIsAMessage"))
+	closeErr := producer.Close()
+	sess.Disconnect()
+
+	batch := RelpBatch.RelpBatch{}
+	batch.Init()
+	left, _ := spool.Replay(&batch)
+	want := "SpooledMessage0,SpooledMessage1,HelloThisIsAMessage"
+	if sendErr != nil || closeErr != nil || strings.Join(received(), ",") != want || left != 0 {
+		t.Errorf("Send and Close returned %v and %v, received %v with %v left in the spool; want nil, nil, %v and 0",
+			sendErr, closeErr, received(), left, want)
+	}
+}
@@ -0,0 +1,112 @@
+package test
+
+import (
+	"errors"
+	"github.com/teragrep/rlp_05/pkg/RelpBatch"
+	"github.com/teragrep/rlp_05/pkg/RelpConnection"
+	"github.com/teragrep/rlp_05/pkg/RelpDialer"
+	"github.com/teragrep/rlp_05/pkg/RelpServer"
+	"net"
+	"testing"
+)
+
+// TestBatchResults: Sends OPEN->SYSLOG(2x)->CLOSE messages to a server rejecting the second message,
+// and puts a third request to the batch after the commit.
+// Checks that Results reports the first request acknowledged, the second rejected with the server's code and text,
+// and the third without a response, each with the txnId it was sent with.
+func TestBatchResults(t *testing.T) {
+	relpServer := RelpServer.RelpServer{Handler: func(payload []byte) error {
+		if string(payload) == "RejectThisMessage" {
+			return errors.New("message not accepted")
+		}
+		return nil
+	}}
+	relpServer.Init()
+	if err := relpServer.Listen("127.0.0.1", 0); err != nil {
+		t.Fatalf("Could not start server: %v", err)
+	}
+	defer relpServer.Close()
+
+	sess := RelpConnection.RelpConnection{RelpDialer: &RelpDialer.RelpPlainDialer{}}
+	sess.Init()
+	ok, _ := sess.Connect("127.0.0.1", relpServer.Addr().(*net.TCPAddr).Port)
+	if !ok {
+		t.Fatalf("Connection was not successful! (success=%v); want true", ok)
+	}
+	msgBatch := RelpBatch.RelpBatch{}
+	msgBatch.Init()
+	msgBatch.Insert([]byte("HelloThisIsAMessage"))
+	msgBatch.Insert([]byte("RejectThisMessage"))
+	err := sess.Commit(&msgBatch)
+	msgBatch.Insert([]byte("NotSentMessage"))
+	sess.Disconnect()
+
+	results := msgBatch.Results()
+	if err != nil || len(results) != 3 {
+		t.Fatalf("Commit returned %v with %v results; want nil and 3", err, len(results))
+	}
+	if results[0].Status != RelpBatch.RESULT_ACKNOWLEDGED || results[0].Code != 200 || results[0].TransactionId != 2 {
+		t.Errorf("First result was %+v; want acknowledged with code 200 and txnId 2", results[0])
+	}
+	if results[1].Status != RelpBatch.RESULT_REJECTED || results[1].Code != 500 ||
+		results[1].Text != "message not accepted" || results[1].TransactionId != 3 {
+		t.Errorf("Second result was %+v; want rejected with code 500, the server's text and txnId 3", results[1])
+	}
+	if results[2].Status != RelpBatch.RESULT_NO_RESPONSE || results[2].RequestId != 3 || results[2].TransactionId != 0 {
+		t.Errorf("Third result was %+v; want no response for request 3 and txnId 0", results[2])
+	}
+}
+
+// TestRetryAllFailedInOrder: Puts 20 requests to a batch, takes them from the work queue and fails them.
+// Checks that RetryAllFailed queues them again in the order they were put to the batch.
+func TestRetryAllFailedInOrder(t *testing.T) {
+	msgBatch := RelpBatch.RelpBatch{}
+	msgBatch.Init()
+	for i := 0; i < 20; i++ {
+		msgBatch.Insert([]byte("HelloThisIsAMessage"))
+	}
+	for msgBatch.GetWorkQueueLen() > 0 {
+		id := msgBatch.PopWorkQueue()
+		msgBatch.PutFailure(id, errors.New("connection lost"))
+	}
+
+	msgBatch.RetryAllFailed()
+
+	for want := uint64(1); want <= 20; want++ {
+		if id := msgBatch.PopWorkQueue(); id != want {
+			t.Fatalf("Work queue returned request %v; want %v", id, want)
+		}
+	}
+}
+
+// TestResultsDuringCommit: Commits 100 messages with CommitAsync and reads the batch's Results until the commit is done.
+// Checks that reading the results while the requests are being sent is safe with -race and that all are acknowledged.
+func TestResultsDuringCommit(t *testing.T) {
+	relpServer, sess, _ := collectingServer(t, serverOptions{connect: true})
+	defer relpServer.Close()
+	defer sess.Disconnect()
+	msgBatch := RelpBatch.RelpBatch{}
+	msgBatch.Init()
+	for i := 0; i < 100; i++ {
+		msgBatch.Insert([]byte("HelloThisIsAMessage"))
+	}
+
+	future := sess.CommitAsync(&msgBatch)
+	for done := false; !done; {
+		select {
+		case <-future.Done():
+			done = true
+		default:
+			msgBatch.Results()
+		}
+	}
+
+	if err := future.Err(); err != nil {
+		t.Fatalf("Commit failed: %v", err)
+	}
+	for _, result := range msgBatch.Results() {
+		if result.Status != RelpBatch.RESULT_ACKNOWLEDGED {
+			t.Errorf("Result was %+v; want acknowledged", result)
+		}
+	}
+}
@@ -1,19 +1,31 @@
 package test
 
 import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
 	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
 	"fmt"
 	"github.com/teragrep/rlp_05/internal/RelpCommand"
 	"github.com/teragrep/rlp_05/internal/RelpFrame"
+	"github.com/teragrep/rlp_05/pkg/Errors"
 	"github.com/teragrep/rlp_05/pkg/RelpBatch"
 	"github.com/teragrep/rlp_05/pkg/RelpConnection"
 	"github.com/teragrep/rlp_05/pkg/RelpDialer"
+	"github.com/teragrep/rlp_05/pkg/RelpServer"
 	"log"
-	"os"
-	"os/exec"
-	"path/filepath"
+	"log/slog"
+	"math/big"
+	"net"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -46,7 +58,7 @@ func TestSingleMessage(t *testing.T) {
 	if err != nil {
 		t.Errorf("Error committing batch (err!=nil); want nil")
 	}
-	disOk := sess.Disconnect()
+	disOk, _ := sess.Disconnect()
 
 	if !disOk {
 		t.Errorf("Disconnection was not successful! (success=%v); want true", disOk)
@@ -63,7 +75,7 @@ func TestSingleMessage(t *testing.T) {
 	}
 
 	// kill server
-	err = relpServer.Process.Kill()
+	err = relpServer.Close()
 	if err != nil {
 		t.Error("Could not kill server\n")
 	}
@@ -106,7 +118,7 @@ func TestMultipleMessage(t *testing.T) {
 		}
 	}
 
-	disOk := sess.Disconnect()
+	disOk, _ := sess.Disconnect()
 
 	if !disOk {
 		t.Errorf("Disconnection was not successful! (success=%v); want true", disOk)
@@ -118,7 +130,7 @@ func TestMultipleMessage(t *testing.T) {
 	}
 
 	// kill server
-	err := relpServer.Process.Kill()
+	err := relpServer.Close()
 	if err != nil {
 		t.Error("Could not kill server\n")
 	}
@@ -163,7 +175,7 @@ func TestMultipleMessageTLS(t *testing.T) {
 		}
 	}
 
-	disOk := sess.Disconnect()
+	disOk, _ := sess.Disconnect()
 
 	if !disOk {
 		t.Errorf("Disconnection was not successful! (success=%v); want true", disOk)
@@ -175,7 +187,7 @@ func TestMultipleMessageTLS(t *testing.T) {
 	}
 
 	// kill server
-	err := relpServer.Process.Kill()
+	err := relpServer.Close()
 	if err != nil {
 		t.Error("Could not kill server\n")
 	}
@@ -237,7 +249,7 @@ func TestMultiMessageBatch(t *testing.T) {
 		}
 	}
 
-	disOk := sess.Disconnect()
+	disOk, _ := sess.Disconnect()
 
 	if !disOk {
 		t.Errorf("Disconnection was not successful! (success=%v); want true", disOk)
@@ -249,7 +261,61 @@ func TestMultiMessageBatch(t *testing.T) {
 	}
 
 	// kill server
-	err := relpServer.Process.Kill()
+	err := relpServer.Close()
+	if err != nil {
+		t.Error("Could not kill server\n")
+	}
+}
+
+// TestPipelinedBatch: Sends OPEN->SYSLOG(100x)->CLOSE messages with a window of 10.
+// Checks that the whole batch gets verified although more frames are sent than the window allows
+// to be pending at once, and that the window and the batch's workQueue are empty afterwards.
+func TestPipelinedBatch(t *testing.T) {
+	relpServer := initServerConnection(false)
+	time.Sleep(time.Second)
+	// server ok, actual test
+	sess := RelpConnection.RelpConnection{RelpDialer: &RelpDialer.RelpPlainDialer{}}
+	sess.Init()
+	sess.MaxWindowSize = 10
+	ok, _ := sess.Connect("127.0.0.1", 1601)
+
+	if !ok {
+		t.Errorf("Connection was not successful! (success=%v); want true", ok)
+	}
+
+	msgBatch := RelpBatch.RelpBatch{}
+	msgBatch.Init()
+	for i := 0; i < 100; i++ {
+		msgBatch.Insert([]byte("HelloThisIsAMessage" + strconv.FormatInt(int64(i), 10)))
+	}
+
+	err := sess.Commit(&msgBatch)
+	if err != nil {
+		t.Errorf("Error committing batch (err!=nil); want nil")
+	}
+
+	if !msgBatch.VerifyTransactionAll() {
+		t.Errorf("Batch could not be verified! (verified=false); want true")
+	}
+
+	// batch queue empty
+	if msgBatch.GetWorkQueueLen() != 0 {
+		t.Errorf("RelpBatch.WorkQueue was not empty! (len=%v); want 0", msgBatch.GetWorkQueueLen())
+	}
+
+	disOk, _ := sess.Disconnect()
+
+	if !disOk {
+		t.Errorf("Disconnection was not successful! (success=%v); want true", disOk)
+	}
+
+	// no stuff pending
+	if sess.Window.Size() != 0 {
+		t.Errorf("RelpConnection.Window was not empty! (size=%v); want 0", sess.Window.Size())
+	}
+
+	// kill server
+	err = relpServer.Close()
 	if err != nil {
 		t.Error("Could not kill server\n")
 	}
@@ -267,13 +333,14 @@ func TestMultiMessageBatchWithDisconnect(t *testing.T) {
 	sess := RelpConnection.RelpConnection{RelpDialer: &RelpDialer.RelpPlainDialer{}}
 	sess.Init()
 	retryRelpConnection(&sess)
+	restartedServer := make(chan *RelpServer.RelpServer, 1)
 
 	for i := 0; i < 3; i++ {
 		syslogMsg := []byte("HelloThisIsAMessage" + strconv.FormatInt(int64(i), 10))
 		syslogMsgLen := len(syslogMsg)
 		msgBatch := RelpBatch.RelpBatch{}
 		msgBatch.Init()
-		msgBatch.PutRequest(&RelpFrame.TX{RelpFrame.Frame{
+		msgBatch.PutRequest(&RelpFrame.TX{Frame: RelpFrame.Frame{
 			Cmd:        RelpCommand.RELP_SYSLOG,
 			DataLength: syslogMsgLen,
 			Data:       syslogMsg,
@@ -282,23 +349,23 @@ func TestMultiMessageBatchWithDisconnect(t *testing.T) {
 		// kill server after first batch for 2 seconds
 		if i == 1 {
 			go func() {
-				err := relpServer.Process.Kill()
+				err := relpServer.Close()
 				if err != nil {
 					t.Errorf("Could not kill server\n")
 				}
 				time.Sleep(2 * time.Second)
-				relpServer = initServerConnection(false)
+				restartedServer <- initServerConnection(false)
 			}()
 		}
 
 		// put 3 messages on batches 0 and 2, and 1 message on batch 1
 		if i != 1 {
-			msgBatch.PutRequest(&RelpFrame.TX{RelpFrame.Frame{
+			msgBatch.PutRequest(&RelpFrame.TX{Frame: RelpFrame.Frame{
 				Cmd:        RelpCommand.RELP_SYSLOG,
 				DataLength: syslogMsgLen,
 				Data:       syslogMsg,
 			}})
-			msgBatch.PutRequest(&RelpFrame.TX{RelpFrame.Frame{
+			msgBatch.PutRequest(&RelpFrame.TX{Frame: RelpFrame.Frame{
 				Cmd:        RelpCommand.RELP_SYSLOG,
 				DataLength: syslogMsgLen,
 				Data:       syslogMsg,
@@ -326,7 +393,7 @@ func TestMultiMessageBatchWithDisconnect(t *testing.T) {
 		}
 	}
 
-	disOk := sess.Disconnect()
+	disOk, _ := sess.Disconnect()
 
 	if !disOk {
 		t.Errorf("Disconnection was not successful! (success=%v); want true", disOk)
@@ -338,7 +405,8 @@ func TestMultiMessageBatchWithDisconnect(t *testing.T) {
 	}
 
 	// kill server
-	err2 := relpServer.Process.Kill()
+	relpServer = <-restartedServer
+	err2 := relpServer.Close()
 	if err2 != nil {
 		t.Errorf("Could not kill server\n")
 	}
@@ -358,6 +426,7 @@ func TestMultiMessageBatchWithDisconnectTLS(t *testing.T) {
 	sess.Init()
 	sess.TlsConfig = &tls.Config{InsecureSkipVerify: true}
 	retryRelpConnection(&sess)
+	restartedServer := make(chan *RelpServer.RelpServer, 1)
 
 	for i := 0; i < 3; i++ {
 		syslogMsg := []byte("HelloThisIsAMessage" + strconv.FormatInt(int64(i), 10))
@@ -373,12 +442,12 @@ func TestMultiMessageBatchWithDisconnectTLS(t *testing.T) {
 		// kill server after first batch for 2 seconds
 		if i == 1 {
 			go func() {
-				err := relpServer.Process.Kill()
+				err := relpServer.Close()
 				if err != nil {
 					t.Errorf("Could not kill server\n")
 				}
 				time.Sleep(2 * time.Second)
-				relpServer = initServerConnection(true)
+				restartedServer <- initServerConnection(true)
 			}()
 		}
 
@@ -417,7 +486,7 @@ func TestMultiMessageBatchWithDisconnectTLS(t *testing.T) {
 		}
 	}
 
-	disOk := sess.Disconnect()
+	disOk, _ := sess.Disconnect()
 
 	if !disOk {
 		t.Errorf("Disconnection was not successful! (success=%v); want true", disOk)
@@ -429,13 +498,590 @@ func TestMultiMessageBatchWithDisconnectTLS(t *testing.T) {
 	}
 
 	// kill server
-	err2 := relpServer.Process.Kill()
+	relpServer = <-restartedServer
+	err2 := relpServer.Close()
 	if err2 != nil {
 		t.Errorf("Could not kill server\n")
 	}
 	fmt.Println("done")
 }
 
+// TestServerCloseDuringCommit: Sends OPEN->SYSLOG, and the server sends serverclose while the SYSLOG is pending.
+// Checks that the commit returns ServerCloseError without waiting for the ACK timeout, and that the
+// pending request was failed with the same error and removed from the window.
+func TestServerCloseDuringCommit(t *testing.T) {
+	entered := make(chan struct{}, 1)
+	release := make(chan struct{})
+	relpServer := RelpServer.RelpServer{Handler: func(_ []byte) error {
+		entered <- struct{}{}
+		<-release
+		return nil
+	}}
+	relpServer.Init()
+	if err := relpServer.Listen("127.0.0.1", 0); err != nil {
+		t.Fatalf("Could not start server: %v", err)
+	}
+
+	sess := RelpConnection.RelpConnection{RelpDialer: &RelpDialer.RelpPlainDialer{}}
+	sess.Init()
+	ok, _ := sess.Connect("127.0.0.1", relpServer.Addr().(*net.TCPAddr).Port)
+	if !ok {
+		t.Fatalf("Connection was not successful! (success=%v); want true", ok)
+	}
+
+	closed := make(chan struct{})
+	go func() {
+		<-entered
+		_ = relpServer.Close()
+		close(closed)
+	}()
+
+	msgBatch := RelpBatch.RelpBatch{}
+	msgBatch.Init()
+	reqId := msgBatch.Insert([]byte("HelloThisIsAMessage"))
+	err := sess.Commit(&msgBatch)
+	close(release)
+	<-closed
+
+	var serverCloseErr *Errors.ServerCloseError
+	if !errors.As(err, &serverCloseErr) {
+		t.Errorf("Commit returned %v; want ServerCloseError", err)
+	}
+	if !errors.As(msgBatch.GetFailure(reqId), &serverCloseErr) {
+		t.Errorf("Request failure was %v; want ServerCloseError", msgBatch.GetFailure(reqId))
+	}
+	if sess.Window.Size() != 0 {
+		t.Errorf("RelpConnection.Window was not empty! (size=%v); want 0", sess.Window.Size())
+	}
+}
+
+// TestServerCloseWhileIdle: Sends OPEN, and the server sends serverclose while no commit is in progress.
+// Checks that the connection becomes closed, committing returning InvalidStateError.
+func TestServerCloseWhileIdle(t *testing.T) {
+	relpServer := RelpServer.RelpServer{}
+	relpServer.Init()
+	if err := relpServer.Listen("127.0.0.1", 0); err != nil {
+		t.Fatalf("Could not start server: %v", err)
+	}
+
+	sess := RelpConnection.RelpConnection{RelpDialer: &RelpDialer.RelpPlainDialer{}}
+	sess.Init()
+	ok, _ := sess.Connect("127.0.0.1", relpServer.Addr().(*net.TCPAddr).Port)
+	if !ok {
+		t.Fatalf("Connection was not successful! (success=%v); want true", ok)
+	}
+	_ = relpServer.Close()
+
+	var invalidStateErr *Errors.InvalidStateError
+	msgBatch := RelpBatch.RelpBatch{}
+	msgBatch.Init()
+	err := sess.Commit(&msgBatch)
+	for deadline := time.Now().Add(5 * time.Second); !errors.As(err, &invalidStateErr) && time.Now().Before(deadline); {
+		time.Sleep(10 * time.Millisecond)
+		err = sess.Commit(&msgBatch)
+	}
+	if !errors.As(err, &invalidStateErr) || invalidStateErr.Current != "CLOSED" {
+		t.Errorf("Commit returned %v; want InvalidStateError in state CLOSED", err)
+	}
+}
+
+// TestAbort: Sends OPEN->ABORT, and reconnects afterwards.
+// Checks that aborting succeeds and that the connection can be established again.
+func TestAbort(t *testing.T) {
+	relpServer := RelpServer.RelpServer{}
+	relpServer.Init()
+	if err := relpServer.Listen("127.0.0.1", 0); err != nil {
+		t.Fatalf("Could not start server: %v", err)
+	}
+	defer relpServer.Close()
+	port := relpServer.Addr().(*net.TCPAddr).Port
+
+	sess := RelpConnection.RelpConnection{RelpDialer: &RelpDialer.RelpPlainDialer{}}
+	sess.Init()
+	ok, _ := sess.Connect("127.0.0.1", port)
+	if !ok {
+		t.Fatalf("Connection was not successful! (success=%v); want true", ok)
+	}
+
+	err := sess.Abort()
+	if err != nil {
+		t.Errorf("Abort returned %v; want nil", err)
+	}
+
+	ok, _ = sess.Connect("127.0.0.1", port)
+	if !ok {
+		t.Errorf("Reconnection was not successful! (success=%v); want true", ok)
+	}
+	sess.Disconnect()
+}
+
+// TestAbortDuringCommitWithReconnectPolicy: Sends OPEN->SYSLOG with a ReconnectPolicy, and aborts while
+// the server has not answered the SYSLOG.
+// Checks that the commit returns AbortError without reconnecting, and that the connection stays closed.
+func TestAbortDuringCommitWithReconnectPolicy(t *testing.T) {
+	blocked := make(chan struct{}, 1)
+	release := make(chan struct{})
+	var received atomic.Int32
+	relpServer := RelpServer.RelpServer{Handler: func(_ []byte) error {
+		// only the first message is left unanswered, a resent one would be acknowledged
+		if received.Add(1) == 1 {
+			blocked <- struct{}{}
+			<-release
+		}
+		return nil
+	}}
+	relpServer.Init()
+	if err := relpServer.Listen("127.0.0.1", 0); err != nil {
+		t.Fatalf("Could not start server: %v", err)
+	}
+	defer relpServer.Close()
+	defer close(release)
+
+	var mutex sync.Mutex
+	var events []int
+	sess := RelpConnection.RelpConnection{RelpDialer: &RelpDialer.RelpPlainDialer{}}
+	sess.Init()
+	sess.ReconnectPolicy = &RelpConnection.ReconnectPolicy{}
+	sess.ReconnectPolicy.Init()
+	sess.ReconnectPolicy.InitialInterval = 10 * time.Millisecond
+	sess.ReconnectPolicy.OnEvent = func(event RelpConnection.ReconnectEvent) {
+		mutex.Lock()
+		events = append(events, event.Type)
+		mutex.Unlock()
+	}
+	ok, _ := sess.Connect("127.0.0.1", relpServer.Addr().(*net.TCPAddr).Port)
+	if !ok {
+		t.Fatalf("Connection was not successful! (success=%v); want true", ok)
+	}
+
+	msgBatch := RelpBatch.RelpBatch{}
+	msgBatch.Init()
+	msgBatch.Insert([]byte("HelloThisIsAMessage"))
+	future := sess.CommitAsync(&msgBatch)
+	<-blocked
+	abortErr := sess.Abort()
+	err := future.Wait()
+	commitErr := sess.Commit(&msgBatch)
+
+	var abortedErr *Errors.AbortError
+	if abortErr != nil || !errors.As(err, &abortedErr) {
+		t.Errorf("Abort returned %v and the commit %v; want nil and AbortError", abortErr, err)
+	}
+	var stateErr *Errors.InvalidStateError
+	if !errors.As(commitErr, &stateErr) {
+		t.Errorf("Commit after the abort returned %v; want InvalidStateError", commitErr)
+	}
+	mutex.Lock()
+	defer mutex.Unlock()
+	if len(events) != 0 {
+		t.Errorf("Got reconnect events %v; want none", events)
+	}
+}
+
+// TestCommitContextTimeout: Sends OPEN->SYSLOG, and the server does not answer the SYSLOG before the context times out.
+// Checks that the commit returns the context's error and that the pending request was failed with it.
+func TestCommitContextTimeout(t *testing.T) {
+	release := make(chan struct{})
+	relpServer := RelpServer.RelpServer{Handler: func(_ []byte) error {
+		<-release
+		return nil
+	}}
+	relpServer.Init()
+	if err := relpServer.Listen("127.0.0.1", 0); err != nil {
+		t.Fatalf("Could not start server: %v", err)
+	}
+	defer relpServer.Close()
+	defer close(release)
+
+	sess := RelpConnection.RelpConnection{RelpDialer: &RelpDialer.RelpPlainDialer{}}
+	sess.Init()
+	ok, _ := sess.Connect("127.0.0.1", relpServer.Addr().(*net.TCPAddr).Port)
+	if !ok {
+		t.Fatalf("Connection was not successful! (success=%v); want true", ok)
+	}
+
+	msgBatch := RelpBatch.RelpBatch{}
+	msgBatch.Init()
+	reqId := msgBatch.Insert([]byte("HelloThisIsAMessage"))
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	err := sess.CommitContext(ctx, &msgBatch)
+
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("CommitContext returned %v; want context.DeadlineExceeded", err)
+	}
+	if !errors.Is(msgBatch.GetFailure(reqId), context.DeadlineExceeded) {
+		t.Errorf("Request failure was %v; want context.DeadlineExceeded", msgBatch.GetFailure(reqId))
+	}
+	if result, _ := msgBatch.Result(reqId); result.Status != RelpBatch.RESULT_FAILED || !errors.Is(result.Err, context.DeadlineExceeded) {
+		t.Errorf("Request result was %+v; want failed with context.DeadlineExceeded", result)
+	}
+}
+
+// TestConnectContextCanceled: Connects using an already canceled context.
+// Checks that the connection is not established and the context's error is returned.
+func TestConnectContextCanceled(t *testing.T) {
+	relpServer := RelpServer.RelpServer{}
+	relpServer.Init()
+	if err := relpServer.Listen("127.0.0.1", 0); err != nil {
+		t.Fatalf("Could not start server: %v", err)
+	}
+	defer relpServer.Close()
+
+	sess := RelpConnection.RelpConnection{RelpDialer: &RelpDialer.RelpPlainDialer{}}
+	sess.Init()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	ok, err := sess.ConnectContext(ctx, "127.0.0.1", relpServer.Addr().(*net.TCPAddr).Port)
+
+	if ok {
+		t.Errorf("Connection was successful! (success=%v); want false", ok)
+	}
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("ConnectContext returned %v; want context.Canceled", err)
+	}
+}
+
+// TestCommitWithoutConnect: Commits a batch on a connection that has not been connected.
+// Checks that InvalidStateError is returned instead of panicking.
+func TestCommitWithoutConnect(t *testing.T) {
+	sess := RelpConnection.RelpConnection{RelpDialer: &RelpDialer.RelpPlainDialer{}}
+	sess.Init()
+	msgBatch := RelpBatch.RelpBatch{}
+	msgBatch.Init()
+	msgBatch.Insert([]byte("HelloThisIsAMessage"))
+
+	err := sess.Commit(&msgBatch)
+
+	var invalidStateErr *Errors.InvalidStateError
+	if !errors.As(err, &invalidStateErr) {
+		t.Errorf("Commit returned %v; want InvalidStateError", err)
+	}
+}
+
+// TestConnectWithoutDialer: Connects without setting the RelpDialer.
+// Checks that DialerNotSetError is returned instead of panicking.
+func TestConnectWithoutDialer(t *testing.T) {
+	sess := RelpConnection.RelpConnection{}
+	sess.Init()
+
+	_, err := sess.Connect("127.0.0.1", 1601)
+
+	var dialerNotSetErr *Errors.DialerNotSetError
+	if !errors.As(err, &dialerNotSetErr) {
+		t.Errorf("Connect returned %v; want DialerNotSetError", err)
+	}
+}
+
+// TestDisconnectWithoutAck: Sends OPEN->CLOSE, and the server drops the connection instead of answering the CLOSE.
+// Checks that the disconnect is not successful and returns an error.
+func TestDisconnectWithoutAck(t *testing.T) {
+	listener, _ := offeringServer(t, "relp_version=0\nrelp_software=test\ncommands=syslog\n")
+	defer listener.Close()
+
+	sess := RelpConnection.RelpConnection{RelpDialer: &RelpDialer.RelpPlainDialer{}}
+	sess.Init()
+	ok, _ := sess.Connect("127.0.0.1", listener.Addr().(*net.TCPAddr).Port)
+	if !ok {
+		t.Fatalf("Connection was not successful! (success=%v); want true", ok)
+	}
+	disOk, err := sess.Disconnect()
+	sess.TearDown()
+
+	var ackErr *Errors.AckReadingError
+	if disOk || !errors.As(err, &ackErr) {
+		t.Errorf("Disconnect returned %v and %v; want false and AckReadingError", disOk, err)
+	}
+}
+
+// TestMalformedResponse: Connects to a server answering the OPEN with a frame that has an invalid txnId.
+// Checks that ProtocolError wrapping ResponseParsingError is returned instead of panicking.
+func TestMalformedResponse(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Could not start server: %v", err)
+	}
+	defer listener.Close()
+	go func() {
+		conn, acceptErr := listener.Accept()
+		if acceptErr != nil {
+			return
+		}
+		defer conn.Close()
+		_, _ = conn.Read(make([]byte, 512))
+		_, _ = conn.Write([]byte("x rsp 6 200 OK\n"))
+		_, _ = conn.Read(make([]byte, 512))
+	}()
+
+	sess := RelpConnection.RelpConnection{RelpDialer: &RelpDialer.RelpPlainDialer{}}
+	sess.Init()
+	ok, err := sess.Connect("127.0.0.1", listener.Addr().(*net.TCPAddr).Port)
+	sess.TearDown()
+
+	var protocolErr *Errors.ProtocolError
+	var parsingErr *Errors.ResponseParsingError
+	if ok {
+		t.Errorf("Connection was successful! (success=%v); want false", ok)
+	}
+	if !errors.As(err, &protocolErr) || !errors.As(err, &parsingErr) {
+		t.Errorf("Connect returned %v; want ProtocolError wrapping ResponseParsingError", err)
+	}
+}
+
+// TestOversizedResponseLength: Connects to servers answering the OPEN with a frame length that does not fit in
+// an int64, and with one exceeding the maximum frame length.
+// Checks that both fail with ProtocolError wrapping ResponseParsingError instead of allocating the length.
+func TestOversizedResponseLength(t *testing.T) {
+	for _, response := range []string{"1 rsp 9223372036854775807 200 OK\n", "1 rsp 999999999 200 OK\n"} {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("Could not start server: %v", err)
+		}
+		go func(response string) {
+			conn, acceptErr := listener.Accept()
+			if acceptErr != nil {
+				return
+			}
+			defer conn.Close()
+			_, _ = conn.Read(make([]byte, 512))
+			_, _ = conn.Write([]byte(response))
+			_, _ = conn.Read(make([]byte, 512))
+		}(response)
+
+		sess := RelpConnection.RelpConnection{RelpDialer: &RelpDialer.RelpPlainDialer{}}
+		sess.Init()
+		ok, err := sess.Connect("127.0.0.1", listener.Addr().(*net.TCPAddr).Port)
+		sess.TearDown()
+		_ = listener.Close()
+
+		var protocolErr *Errors.ProtocolError
+		var parsingErr *Errors.ResponseParsingError
+		if ok || !errors.As(err, &protocolErr) || !errors.As(err, &parsingErr) {
+			t.Errorf("Connect with response %q returned (%v, %v); want false and ProtocolError wrapping "+
+				"ResponseParsingError", response, ok, err)
+		}
+	}
+}
+
+// TestLoggerTracesWithoutPayload: Sends OPEN->SYSLOG->CLOSE messages using a debug level Logger.
+// Checks that the frames are traced to the given logger and that the payload is not logged.
+func TestLoggerTracesWithoutPayload(t *testing.T) {
+	relpServer := RelpServer.RelpServer{}
+	relpServer.Init()
+	if err := relpServer.Listen("127.0.0.1", 0); err != nil {
+		t.Fatalf("Could not start server: %v", err)
+	}
+	defer relpServer.Close()
+
+	logOutput := &bytes.Buffer{}
+	sess := RelpConnection.RelpConnection{RelpDialer: &RelpDialer.RelpPlainDialer{}}
+	sess.Init()
+	sess.Logger = slog.New(slog.NewTextHandler(logOutput, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	ok, _ := sess.Connect("127.0.0.1", relpServer.Addr().(*net.TCPAddr).Port)
+	if !ok {
+		t.Fatalf("Connection was not successful! (success=%v); want true", ok)
+	}
+	msgBatch := RelpBatch.RelpBatch{}
+	msgBatch.Init()
+	msgBatch.Insert([]byte("SecretPayload"))
+	_ = sess.Commit(&msgBatch)
+	msgBatch.VerifyTransactionAll()
+	sess.Disconnect()
+
+	if !strings.Contains(logOutput.String(), "SendBatch> Sending request") {
+		t.Errorf("Logger output did not contain the frame tracing; want it to")
+	}
+	if !strings.Contains(logOutput.String(), "Transaction successfully verified") {
+		t.Errorf("Logger output did not contain the batch verification; want it to")
+	}
+	if strings.Contains(logOutput.String(), "SecretPayload") {
+		t.Errorf("Logger output contained the payload; want it not to")
+	}
+}
+
+// TestReconnectPolicyResends: Connects, restarts the server, and commits a batch with a ReconnectPolicy.
+// Checks that the commit reconnects and resends the batch, and that the reconnect events were emitted.
+func TestReconnectPolicyResends(t *testing.T) {
+	relpServer := RelpServer.RelpServer{}
+	relpServer.Init()
+	if err := relpServer.Listen("127.0.0.1", 0); err != nil {
+		t.Fatalf("Could not start server: %v", err)
+	}
+	port := relpServer.Addr().(*net.TCPAddr).Port
+
+	var events []int
+	sess := RelpConnection.RelpConnection{RelpDialer: &RelpDialer.RelpPlainDialer{}}
+	sess.Init()
+	sess.ReconnectPolicy = &RelpConnection.ReconnectPolicy{}
+	sess.ReconnectPolicy.Init()
+	sess.ReconnectPolicy.InitialInterval = 10 * time.Millisecond
+	sess.ReconnectPolicy.OnEvent = func(event RelpConnection.ReconnectEvent) {
+		events = append(events, event.Type)
+	}
+	ok, _ := sess.Connect("127.0.0.1", port)
+	if !ok {
+		t.Fatalf("Connection was not successful! (success=%v); want true", ok)
+	}
+
+	_ = relpServer.Close()
+	if err := relpServer.Listen("127.0.0.1", port); err != nil {
+		t.Fatalf("Could not restart server: %v", err)
+	}
+	defer relpServer.Close()
+
+	msgBatch := RelpBatch.RelpBatch{}
+	msgBatch.Init()
+	msgBatch.Insert([]byte("HelloThisIsAMessage1"))
+	msgBatch.Insert([]byte("HelloThisIsAMessage2"))
+	err := sess.Commit(&msgBatch)
+
+	if err != nil {
+		t.Errorf("Commit returned %v; want nil", err)
+	}
+	if !msgBatch.VerifyTransactionAll() {
+		t.Errorf("Batch could not be verified! (verified=false); want true")
+	}
+	if len(events) != 2 || events[0] != RelpConnection.EVENT_RECONNECTING || events[1] != RelpConnection.EVENT_RECONNECTED {
+		t.Errorf("Got reconnect events %v; want [RECONNECTING RECONNECTED]", events)
+	}
+	sess.Disconnect()
+}
+
+// TestReconnectPolicyGivesUp: Connects, stops the server, and commits a batch with a ReconnectPolicy
+// limited to two attempts. Checks that the commit fails and that the policy emitted the gave up event.
+func TestReconnectPolicyGivesUp(t *testing.T) {
+	relpServer := RelpServer.RelpServer{}
+	relpServer.Init()
+	if err := relpServer.Listen("127.0.0.1", 0); err != nil {
+		t.Fatalf("Could not start server: %v", err)
+	}
+
+	var lastEvent RelpConnection.ReconnectEvent
+	sess := RelpConnection.RelpConnection{RelpDialer: &RelpDialer.RelpPlainDialer{}}
+	sess.Init()
+	sess.ReconnectPolicy = &RelpConnection.ReconnectPolicy{}
+	sess.ReconnectPolicy.Init()
+	sess.ReconnectPolicy.InitialInterval = 10 * time.Millisecond
+	sess.ReconnectPolicy.MaxAttempts = 2
+	sess.ReconnectPolicy.OnEvent = func(event RelpConnection.ReconnectEvent) {
+		lastEvent = event
+	}
+	ok, _ := sess.Connect("127.0.0.1", relpServer.Addr().(*net.TCPAddr).Port)
+	if !ok {
+		t.Fatalf("Connection was not successful! (success=%v); want true", ok)
+	}
+	_ = relpServer.Close()
+
+	msgBatch := RelpBatch.RelpBatch{}
+	msgBatch.Init()
+	msgBatch.Insert([]byte("HelloThisIsAMessage"))
+	err := sess.Commit(&msgBatch)
+
+	var connErr *Errors.ConnectionEstablishmentError
+	if !errors.As(err, &connErr) {
+		t.Errorf("Commit returned %v; want ConnectionEstablishmentError", err)
+	}
+	if lastEvent.Type != RelpConnection.EVENT_GAVE_UP || lastEvent.Attempt != 2 {
+		t.Errorf("Last reconnect event was %v after attempt %v; want GAVE_UP after attempt 2", lastEvent.Type, lastEvent.Attempt)
+	}
+}
+
+// TestConcurrentCommits: Sends OPEN->SYSLOG(8x50)->CLOSE messages, committing eight batches from their own goroutines
+// at the same time on a single connection with a window smaller than the batches.
+// Checks that each batch was verified, and that the server received every message exactly once.
+func TestConcurrentCommits(t *testing.T) {
+	relpServer, _, received := collectingServer(t, serverOptions{listen: true})
+	defer relpServer.Close()
+
+	sess := RelpConnection.RelpConnection{RelpDialer: &RelpDialer.RelpPlainDialer{}}
+	sess.Init()
+	sess.MaxWindowSize = 16
+	ok, _ := sess.Connect("127.0.0.1", relpServer.Addr().(*net.TCPAddr).Port)
+	if !ok {
+		t.Fatalf("Connection was not successful! (success=%v); want true", ok)
+	}
+
+	var waitGroup sync.WaitGroup
+	batches := make([]*RelpBatch.RelpBatch, 8)
+	errs := make([]error, len(batches))
+	for i := range batches {
+		batches[i] = &RelpBatch.RelpBatch{}
+		batches[i].Init()
+		for j := 0; j < 50; j++ {
+			batches[i].Insert([]byte(fmt.Sprintf("HelloThisIsAMessage%v-%v", i, j)))
+		}
+		waitGroup.Add(1)
+		go func(i int) {
+			defer waitGroup.Done()
+			errs[i] = sess.Commit(batches[i])
+		}(i)
+	}
+	waitGroup.Wait()
+
+	for i, batch := range batches {
+		if errs[i] != nil || !batch.VerifyTransactionAll() {
+			t.Errorf("Batch %v returned %v and was verified=%v; want nil and true", i, errs[i], batch.VerifyTransactionAll())
+		}
+	}
+	disOk, _ := sess.Disconnect()
+	if !disOk {
+		t.Errorf("Disconnection was not successful! (success=%v); want true", disOk)
+	}
+
+	unique := make(map[string]struct{})
+	for _, message := range received() {
+		unique[message] = struct{}{}
+	}
+	if len(received()) != 400 || len(unique) != 400 {
+		t.Errorf("Server received %v messages, %v unique; want 400 and 400", len(received()), len(unique))
+	}
+}
+
+// TestCommitAsync: Sends OPEN->SYSLOG(3x10)->CLOSE messages, committing three batches with CommitAsync
+// before waiting for any of them, with a response callback on each batch.
+// Checks that the futures resolve without errors, and that the callback was called for every request.
+func TestCommitAsync(t *testing.T) {
+	relpServer, _, _ := collectingServer(t, serverOptions{listen: true})
+	defer relpServer.Close()
+
+	sess := RelpConnection.RelpConnection{RelpDialer: &RelpDialer.RelpPlainDialer{}}
+	sess.Init()
+	ok, _ := sess.Connect("127.0.0.1", relpServer.Addr().(*net.TCPAddr).Port)
+	if !ok {
+		t.Fatalf("Connection was not successful! (success=%v); want true", ok)
+	}
+
+	var mutex sync.Mutex
+	responded := make(map[*RelpBatch.RelpBatch]int)
+	futures := make([]*RelpConnection.CommitFuture, 3)
+	for i := range futures {
+		msgBatch := &RelpBatch.RelpBatch{}
+		msgBatch.Init()
+		msgBatch.OnResponse = func(id uint64, response *RelpFrame.RX) {
+			mutex.Lock()
+			responded[msgBatch]++
+			mutex.Unlock()
+		}
+		for j := 0; j < 10; j++ {
+			msgBatch.Insert([]byte(fmt.Sprintf("HelloThisIsAMessage%v-%v", i, j)))
+		}
+		futures[i] = sess.CommitAsync(msgBatch)
+	}
+
+	for i, future := range futures {
+		err := future.Wait()
+		if err != nil || !future.Batch().VerifyTransactionAll() {
+			t.Errorf("Future %v resolved to %v and was verified=%v; want nil and true", i, err, future.Batch().VerifyTransactionAll())
+		}
+		mutex.Lock()
+		if responded[future.Batch()] != 10 {
+			t.Errorf("OnResponse was called %v times for batch %v; want 10", responded[future.Batch()], i)
+		}
+		mutex.Unlock()
+	}
+	sess.Disconnect()
+}
+
 // Utils for testing
 
 // retryRelpConnection disconnects and attempts to reconnect to the server every 5 seconds until succeeds
@@ -452,42 +1098,42 @@ func retryRelpConnection(relpSess *RelpConnection.RelpConnection) {
 	}
 }
 
-// initServerConnection initializes the relp server using a Java-based relp server
-// the java demo relp server is hardcoded to run on 127.0.0.1:1601
-func initServerConnection(tlsMode bool) *exec.Cmd {
-	ready := make(chan int, 1)
+// initServerConnection initializes the relp server using the in-process RelpServer
+// the test server is hardcoded to run on 127.0.0.1:1601
+func initServerConnection(tlsMode bool) *RelpServer.RelpServer {
+	relpServer := &RelpServer.RelpServer{}
+	relpServer.Init()
 
-	// get relp server jar
-	wd, _ := os.Getwd()
-	par := filepath.Dir(wd)
-	for !strings.HasSuffix(par, "/rlp_05") {
-		par = filepath.Dir(par)
-	}
-	jarLocation := par + "/resources/relp-server/java-relp-server-demo-jar-with-dependencies.jar"
-
-	if _, err := os.Stat(jarLocation); err != nil {
-		panic("No relp server jar found in " + jarLocation)
-	}
-	log.Printf("get relp server jar %v\n", jarLocation)
-
-	// run it
-	var relpServer *exec.Cmd
+	var err error
 	if tlsMode {
-		relpServer = exec.Command("/usr/bin/java",
-			"-jar", jarLocation, "tls=true")
+		err = relpServer.ListenTLS("127.0.0.1", 1601, &tls.Config{Certificates: []tls.Certificate{selfSignedCertificate()}})
 	} else {
-		relpServer = exec.Command("/usr/bin/java",
-			"-jar", jarLocation)
+		err = relpServer.Listen("127.0.0.1", 1601)
+	}
+	if err != nil {
+		panic("failed to start relp server: " + err.Error())
 	}
-
-	// start in coroutine
-	go func() {
-		srvErr := relpServer.Start()
-		if srvErr != nil {
-			panic("failed to start relp server")
-		}
-		ready <- 1
-	}()
-	<-ready
 	return relpServer
 }
+
+// selfSignedCertificate generates a certificate for 127.0.0.1 to be used by the TLS test server
+func selfSignedCertificate() tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		panic("failed to generate key: " + err.Error())
+	}
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "127.0.0.1"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		panic("failed to create certificate: " + err.Error())
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
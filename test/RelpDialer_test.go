@@ -0,0 +1,298 @@
+package test
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"github.com/teragrep/rlp_05/pkg/Errors"
+	"github.com/teragrep/rlp_05/pkg/RelpBatch"
+	"github.com/teragrep/rlp_05/pkg/RelpConnection"
+	"github.com/teragrep/rlp_05/pkg/RelpDialer"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// stubResolver resolves the names from its maps instead of DNS
+type stubResolver struct {
+	srv   map[string][]*net.SRV
+	hosts map[string][]string
+}
+
+func (resolver *stubResolver) LookupSRV(_ context.Context, _, _, name string) (string, []*net.SRV, error) {
+	records, ok := resolver.srv[name]
+	if !ok {
+		return "", nil, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+	}
+	return name, records, nil
+}
+
+func (resolver *stubResolver) LookupIPAddr(_ context.Context, host string) ([]net.IPAddr, error) {
+	ips, ok := resolver.hosts[host]
+	if !ok {
+		return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+	}
+	addrs := make([]net.IPAddr, len(ips))
+	for i, ip := range ips {
+		addrs[i] = net.IPAddr{IP: net.ParseIP(ip)}
+	}
+	return addrs, nil
+}
+
+// TestDialTriesAllAddresses: Resolves a hostname to an address nothing listens on, followed by the server's address.
+// Checks that the connection is established using the second address.
+func TestDialTriesAllAddresses(t *testing.T) {
+	relpServer, _, received := collectingServer(t, serverOptions{listen: true})
+	defer relpServer.Close()
+	resolver := &stubResolver{hosts: map[string][]string{"relp.test": {"127.0.0.2", "127.0.0.1"}}}
+
+	sess := RelpConnection.RelpConnection{RelpDialer: &RelpDialer.RelpPlainDialer{Resolver: resolver}}
+	sess.Init()
+	ok, err := sess.Connect("relp.test", relpServer.Addr().(*net.TCPAddr).Port)
+	if !ok || err != nil {
+		t.Fatalf("Connection was not successful! (success=%v, err=%v); want true", ok, err)
+	}
+	batch := RelpBatch.RelpBatch{}
+	batch.Init()
+	batch.Insert([]byte("HelloThisIsAMessage"))
+	_ = sess.Commit(&batch)
+	sess.Disconnect()
+
+	if len(received()) != 1 {
+		t.Errorf("Server received %v messages; want 1", len(received()))
+	}
+}
+
+// TestDialResolvesSRV: Resolves an SRV record with a higher priority target nothing listens on,
+// and a lower priority target of the server. Checks that the connection is established using the SRV port.
+func TestDialResolvesSRV(t *testing.T) {
+	relpServer, _, _ := collectingServer(t, serverOptions{listen: true})
+	defer relpServer.Close()
+	dead := deadEndpoint(t)
+	resolver := &stubResolver{
+		srv: map[string][]*net.SRV{"_relp._tcp.example.test": {
+			{Target: "primary.example.test.", Port: uint16(dead.Port), Priority: 10},
+			{Target: "secondary.example.test.", Port: uint16(relpServer.Addr().(*net.TCPAddr).Port), Priority: 20},
+		}},
+		hosts: map[string][]string{"primary.example.test": {"127.0.0.1"}, "secondary.example.test": {"127.0.0.1"}},
+	}
+
+	sess := RelpConnection.RelpConnection{RelpDialer: &RelpDialer.RelpPlainDialer{Resolver: resolver}}
+	sess.Init()
+	ok, err := sess.Connect("_relp._tcp.example.test", 0)
+	sess.Disconnect()
+
+	if !ok || err != nil {
+		t.Errorf("Connection was not successful! (success=%v, err=%v); want true", ok, err)
+	}
+}
+
+// TestDialReportsAddress: Resolves a hostname to addresses nothing listens on.
+// Checks that the ConnectionEstablishmentError reports the address of the last attempt.
+func TestDialReportsAddress(t *testing.T) {
+	dead := deadEndpoint(t)
+	resolver := &stubResolver{hosts: map[string][]string{"relp.test": {"127.0.0.2", "127.0.0.1"}}}
+
+	sess := RelpConnection.RelpConnection{RelpDialer: &RelpDialer.RelpPlainDialer{Resolver: resolver, FallbackDelay: -1}}
+	sess.Init()
+	ok, err := sess.Connect("relp.test", dead.Port)
+
+	var connErr *Errors.ConnectionEstablishmentError
+	want := fmt.Sprintf("127.0.0.1:%v", dead.Port)
+	if ok || !errors.As(err, &connErr) || connErr.Address != want {
+		t.Errorf("Connect returned (%v, %v); want false and ConnectionEstablishmentError for 127.0.0.1", ok, err)
+	}
+}
+
+// TestUnixDialer: Sends a message over a Unix domain socket using the path given as the hostname,
+// and over an abstract socket on Linux using the Path of the dialer. Checks that both were received.
+func TestUnixDialer(t *testing.T) {
+	paths := []string{filepath.Join(t.TempDir(), "relp.sock")}
+	if runtime.GOOS == "linux" {
+		paths = append(paths, fmt.Sprintf("@rlp_05-test-%v", os.Getpid()))
+	}
+	for _, path := range paths {
+		relpServer, _, received := collectingServer(t, serverOptions{})
+		if err := relpServer.ListenUnix(path); err != nil {
+			t.Fatalf("Could not start server: %v", err)
+		}
+
+		dialer := &RelpDialer.RelpUnixDialer{}
+		hostname := path
+		if path[0] == '@' {
+			dialer.Path = path
+			hostname = ""
+		}
+		sess := RelpConnection.RelpConnection{RelpDialer: dialer}
+		sess.Init()
+		ok, err := sess.Connect(hostname, 0)
+		if !ok || err != nil {
+			t.Fatalf("Connection to %v was not successful! (success=%v, err=%v); want true", path, ok, err)
+		}
+		batch := RelpBatch.RelpBatch{}
+		batch.Init()
+		batch.Insert([]byte("HelloThisIsAMessage"))
+		commitErr := sess.Commit(&batch)
+		sess.Disconnect()
+		_ = relpServer.Close()
+
+		if commitErr != nil || len(received()) != 1 {
+			t.Errorf("Commit to %v returned %v and server received %v messages; want nil and 1",
+				path, commitErr, len(received()))
+		}
+	}
+}
+
+// TestUnixDialerReportsPath: Connects to a socket path nothing listens on.
+// Checks that the ConnectionEstablishmentError reports the path and the unix protocol.
+func TestUnixDialerReportsPath(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "missing.sock")
+	sess := RelpConnection.RelpConnection{RelpDialer: &RelpDialer.RelpUnixDialer{Path: path}}
+	sess.Init()
+	ok, err := sess.Connect("", 0)
+
+	var connErr *Errors.ConnectionEstablishmentError
+	if ok || !errors.As(err, &connErr) || connErr.Address != path || connErr.Protocol != "unix" {
+		t.Errorf("Connect returned (%v, %v); want false and ConnectionEstablishmentError for %v", ok, err, path)
+	}
+}
+
+// TestConnDialerOverPipe: Commits a message over net.Pipe to a server serving the other end.
+// Checks that the message was received, and that the wrapped connection can't be dialed again.
+func TestConnDialerOverPipe(t *testing.T) {
+	relpServer, _, received := collectingServer(t, serverOptions{})
+	clientEnd, serverEnd := net.Pipe()
+	if err := relpServer.ServeConn(serverEnd); err != nil {
+		t.Fatalf("Could not serve the pipe: %v", err)
+	}
+	defer relpServer.Close()
+
+	sess := RelpConnection.RelpConnection{RelpDialer: RelpDialer.NewRelpConnDialer(clientEnd)}
+	sess.Init()
+	ok, err := sess.Connect("pipe", 0)
+	if !ok || err != nil {
+		t.Fatalf("Connection was not successful! (success=%v, err=%v); want true", ok, err)
+	}
+	batch := RelpBatch.RelpBatch{}
+	batch.Init()
+	batch.Insert([]byte("HelloThisIsAMessage"))
+	commitErr := sess.Commit(&batch)
+	sess.Disconnect()
+	reconnected, _ := sess.Connect("pipe", 0)
+
+	if commitErr != nil || len(received()) != 1 {
+		t.Errorf("Commit returned %v and server received %v messages; want nil and 1", commitErr, len(received()))
+	}
+	if reconnected {
+		t.Errorf("Reconnecting with the used pipe was successful; want false")
+	}
+}
+
+// TestDialFuncGetsUnresolvedAddress: Connects using a DialFunc returning a pipe to the server.
+// Checks that the function was called with the unresolved hostname and port.
+func TestDialFuncGetsUnresolvedAddress(t *testing.T) {
+	relpServer, _, _ := collectingServer(t, serverOptions{})
+	defer relpServer.Close()
+	var dialed string
+	dialer := &RelpDialer.RelpPlainDialer{DialFunc: func(_ context.Context, network string, address string) (net.Conn, error) {
+		dialed = network + " " + address
+		clientEnd, serverEnd := net.Pipe()
+		return clientEnd, relpServer.ServeConn(serverEnd)
+	}}
+
+	sess := RelpConnection.RelpConnection{RelpDialer: dialer}
+	sess.Init()
+	ok, err := sess.Connect("collector.invalid", 1601)
+	sess.Disconnect()
+
+	if !ok || err != nil || dialed != "tcp collector.invalid:1601" {
+		t.Errorf("Connect returned (%v, %v) dialing %q; want true dialing \"tcp collector.invalid:1601\"", ok, err, dialed)
+	}
+}
+
+// dialOnlyDialer is a RelpDialer implementing only the methods of the RelpDialer interface, without DialContext
+type dialOnlyDialer struct {
+	RelpDialer.RelpDialer
+}
+
+// TestConnectContextWithDialOnlyDialer: Connects with ConnectContext using a dialer without DialContext,
+// and commits a message. Checks that the dialer is dialed with Dial and the message is received.
+func TestConnectContextWithDialOnlyDialer(t *testing.T) {
+	relpServer, _, received := collectingServer(t, serverOptions{listen: true})
+	defer relpServer.Close()
+	dialer := dialOnlyDialer{&RelpDialer.RelpPlainDialer{}}
+	if _, ok := RelpDialer.RelpDialer(dialer).(RelpDialer.RelpContextDialer); ok {
+		t.Fatalf("dialOnlyDialer implements RelpContextDialer; want only RelpDialer")
+	}
+
+	sess := RelpConnection.RelpConnection{RelpDialer: dialer}
+	sess.Init()
+	ok, err := sess.ConnectContext(context.Background(), "127.0.0.1", relpServer.Addr().(*net.TCPAddr).Port)
+	if !ok || err != nil {
+		t.Fatalf("Connection was not successful! (success=%v, err=%v); want true", ok, err)
+	}
+	batch := RelpBatch.RelpBatch{}
+	batch.Init()
+	batch.Insert([]byte("HelloThisIsAMessage"))
+	commitErr := sess.Commit(&batch)
+	sess.Disconnect()
+
+	if commitErr != nil || len(received()) != 1 {
+		t.Errorf("Commit returned %v and server received %v messages; want nil and 1", commitErr, len(received()))
+	}
+}
+
+// addresslessConn is a connection without a remote address
+type addresslessConn struct {
+	net.Conn
+}
+
+func (conn addresslessConn) RemoteAddr() net.Addr {
+	return nil
+}
+
+// TestTLSDialFuncWithoutRemoteAddress: Dials with TLS using a DialFunc returning a connection without a remote
+// address, whose other end is closed. Checks that the failed handshake reports the dialed address.
+func TestTLSDialFuncWithoutRemoteAddress(t *testing.T) {
+	dialer := &RelpDialer.RelpTLSDialer{DialFunc: func(_ context.Context, _ string, _ string) (net.Conn, error) {
+		clientEnd, serverEnd := net.Pipe()
+		_ = serverEnd.Close()
+		return addresslessConn{clientEnd}, nil
+	}}
+
+	_, err := dialer.DialContext(context.Background(), "collector.invalid", 6514, &tls.Config{})
+
+	var dialErr *Errors.DialError
+	if !errors.As(err, &dialErr) || dialErr.Address != "collector.invalid:6514" {
+		t.Errorf("DialContext returned %v; want DialError for collector.invalid:6514", err)
+	}
+}
+
+// TestNetDialerOptions: Connects using a net.Dialer with a Control function for socket options.
+// Checks that the function was called for the connection.
+func TestNetDialerOptions(t *testing.T) {
+	listener, _ := offeringServer(t, "relp_version=0\nrelp_software=fake\ncommands=syslog\n")
+	defer listener.Close()
+	controlled := false
+	dialer := &RelpDialer.RelpPlainDialer{Dialer: &net.Dialer{
+		Timeout: 5 * time.Second,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			controlled = true
+			return nil
+		},
+	}}
+
+	sess := RelpConnection.RelpConnection{RelpDialer: dialer}
+	sess.Init()
+	ok, err := sess.Connect("127.0.0.1", listener.Addr().(*net.TCPAddr).Port)
+	sess.TearDown()
+
+	if !ok || err != nil || !controlled {
+		t.Errorf("Connect returned (%v, %v) with Control called %v; want true and called", ok, err, controlled)
+	}
+}
@@ -0,0 +1,131 @@
+package test
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"github.com/teragrep/rlp_05/pkg/Errors"
+	"github.com/teragrep/rlp_05/pkg/RelpConnection"
+	"github.com/teragrep/rlp_05/pkg/RelpDialer"
+	"github.com/teragrep/rlp_05/pkg/RelpServer"
+	"net"
+	"testing"
+)
+
+// startTLSServer starts a TLS RelpServer with a self-signed certificate, returning the parsed certificate
+func startTLSServer(t *testing.T) (*RelpServer.RelpServer, *x509.Certificate) {
+	certificate := selfSignedCertificate()
+	relpServer, _, _ := collectingServer(t, serverOptions{})
+	if err := relpServer.ListenTLS("127.0.0.1", 0, &tls.Config{Certificates: []tls.Certificate{certificate}}); err != nil {
+		t.Fatalf("Could not start server: %v", err)
+	}
+	parsed, _ := x509.ParseCertificate(certificate.Certificate[0])
+	return relpServer, parsed
+}
+
+// connectTLS connects to the server with the dialer and config, and disconnects
+func connectTLS(relpServer *RelpServer.RelpServer, dialer *RelpDialer.RelpTLSDialer, cfg *tls.Config) (bool, error) {
+	sess := RelpConnection.RelpConnection{RelpDialer: dialer}
+	sess.Init()
+	sess.TlsConfig = cfg
+	ok, err := sess.Connect("127.0.0.1", relpServer.Addr().(*net.TCPAddr).Port)
+	if ok {
+		sess.Disconnect()
+	}
+	return ok, err
+}
+
+// TestPinnedPublicKey: Connects with the server's public key pinned, both with and without chain verification,
+// and with another key pinned. Checks that only the matching pin connects, and the mismatch is a
+// PeerVerificationError instead of a ConnectionEstablishmentError.
+func TestPinnedPublicKey(t *testing.T) {
+	relpServer, certificate := startTLSServer(t)
+	defer relpServer.Close()
+	roots := x509.NewCertPool()
+	roots.AddCert(certificate)
+	otherCertificate, _ := x509.ParseCertificate(selfSignedCertificate().Certificate[0])
+
+	pinned := []string{RelpDialer.SPKIHash(certificate)}
+	skipOk, skipErr := connectTLS(relpServer, &RelpDialer.RelpTLSDialer{PinnedSPKIHashes: pinned},
+		&tls.Config{InsecureSkipVerify: true})
+	verifiedOk, verifiedErr := connectTLS(relpServer, &RelpDialer.RelpTLSDialer{PinnedSPKIHashes: pinned},
+		&tls.Config{RootCAs: roots})
+	if !skipOk || !verifiedOk {
+		t.Errorf("Connections with the pinned key failed with %v and %v; want success", skipErr, verifiedErr)
+	}
+
+	otherPin := []string{RelpDialer.SPKIHash(otherCertificate)}
+	ok, err := connectTLS(relpServer, &RelpDialer.RelpTLSDialer{PinnedSPKIHashes: otherPin},
+		&tls.Config{InsecureSkipVerify: true})
+	var verificationErr *Errors.PeerVerificationError
+	var connErr *Errors.ConnectionEstablishmentError
+	if ok || !errors.As(err, &verificationErr) || errors.As(err, &connErr) {
+		t.Errorf("Connect with another pinned key returned (%v, %v); want false and PeerVerificationError", ok, err)
+	}
+}
+
+// TestAllowedNames: Connects with the server's IP SAN allowed, and with only another name allowed.
+// Checks that the other name fails with PeerVerificationError, and the config's VerifyConnection is still called.
+func TestAllowedNames(t *testing.T) {
+	relpServer, certificate := startTLSServer(t)
+	defer relpServer.Close()
+	roots := x509.NewCertPool()
+	roots.AddCert(certificate)
+	verifyCalls := 0
+	cfg := &tls.Config{RootCAs: roots, VerifyConnection: func(tls.ConnectionState) error {
+		verifyCalls++
+		return nil
+	}}
+
+	ok, err := connectTLS(relpServer, &RelpDialer.RelpTLSDialer{AllowedNames: []string{"127.0.0.1"}}, cfg)
+	if !ok || verifyCalls != 1 {
+		t.Errorf("Connect with an allowed name returned (%v, %v) with %v VerifyConnection calls; want true and 1",
+			ok, err, verifyCalls)
+	}
+
+	ok, err = connectTLS(relpServer, &RelpDialer.RelpTLSDialer{AllowedNames: []string{"collector.example.com"}}, cfg)
+	var verificationErr *Errors.PeerVerificationError
+	if ok || !errors.As(err, &verificationErr) {
+		t.Errorf("Connect without an allowed name returned (%v, %v); want false and PeerVerificationError", ok, err)
+	}
+}
+
+// TestAllowedNamesWithoutVerification: Connects with an allowed name while skipping the chain verification,
+// with and without a pinned public key. Checks that only the pinned one connects, and the other is rejected
+// with PeerVerificationError before the handshake.
+func TestAllowedNamesWithoutVerification(t *testing.T) {
+	relpServer, certificate := startTLSServer(t)
+	defer relpServer.Close()
+	verifyCalls := 0
+	cfg := &tls.Config{InsecureSkipVerify: true, VerifyConnection: func(tls.ConnectionState) error {
+		verifyCalls++
+		return nil
+	}}
+	names := []string{"127.0.0.1"}
+
+	ok, err := connectTLS(relpServer, &RelpDialer.RelpTLSDialer{AllowedNames: names}, cfg)
+	var verificationErr *Errors.PeerVerificationError
+	if ok || !errors.As(err, &verificationErr) || verifyCalls != 0 {
+		t.Errorf("Connect without a pin returned (%v, %v) with %v VerifyConnection calls; want false, "+
+			"PeerVerificationError and 0", ok, err, verifyCalls)
+	}
+
+	pinned := []string{RelpDialer.SPKIHash(certificate)}
+	ok, err = connectTLS(relpServer, &RelpDialer.RelpTLSDialer{AllowedNames: names, PinnedSPKIHashes: pinned}, cfg)
+	if !ok {
+		t.Errorf("Connect with a pin returned (%v, %v); want true", ok, err)
+	}
+}
+
+// TestUntrustedCertificate: Connects to a server with a certificate not signed by a trusted CA.
+// Checks that Connect fails with PeerVerificationError.
+func TestUntrustedCertificate(t *testing.T) {
+	relpServer, _ := startTLSServer(t)
+	defer relpServer.Close()
+
+	ok, err := connectTLS(relpServer, &RelpDialer.RelpTLSDialer{}, &tls.Config{RootCAs: x509.NewCertPool()})
+	var verificationErr *Errors.PeerVerificationError
+	if ok || !errors.As(err, &verificationErr) {
+		t.Errorf("Connect returned (%v, %v); want false and PeerVerificationError", ok, err)
+	}
+}
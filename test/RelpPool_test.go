@@ -0,0 +1,213 @@
+package test
+
+import (
+	"errors"
+	"github.com/teragrep/rlp_05/pkg/Errors"
+	"github.com/teragrep/rlp_05/pkg/RelpBatch"
+	"github.com/teragrep/rlp_05/pkg/RelpPool"
+	"github.com/teragrep/rlp_05/pkg/RelpServer"
+	"net"
+	"testing"
+)
+
+// serverEndpoint returns the pool endpoint of the server
+func serverEndpoint(addr net.Addr) RelpPool.Endpoint {
+	return RelpPool.Endpoint{Hostname: "127.0.0.1", Port: addr.(*net.TCPAddr).Port}
+}
+
+// deadEndpoint returns an endpoint no server is listening on
+func deadEndpoint(t *testing.T) RelpPool.Endpoint {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Could not reserve a port: %v", err)
+	}
+	_ = listener.Close()
+	return serverEndpoint(listener.Addr())
+}
+
+// commitMessages commits a batch with the given amount of messages to the pool
+func commitMessages(pool *RelpPool.RelpPool, count int) (*RelpBatch.RelpBatch, error) {
+	batch := &RelpBatch.RelpBatch{}
+	batch.Init()
+	for i := 0; i < count; i++ {
+		batch.Insert([]byte("HelloThisIsAMessage"))
+	}
+	return batch, pool.Commit(batch)
+}
+
+// TestPoolRoundRobin: Commits four batches to a round-robin pool of two servers.
+// Checks that both servers received two of them.
+func TestPoolRoundRobin(t *testing.T) {
+	first, _, firstReceived := collectingServer(t, serverOptions{listen: true})
+	defer first.Close()
+	second, _, secondReceived := collectingServer(t, serverOptions{listen: true})
+	defer second.Close()
+	pool := RelpPool.RelpPool{Endpoints: []RelpPool.Endpoint{serverEndpoint(first.Addr()), serverEndpoint(second.Addr())}}
+	pool.Init()
+	if err := pool.Connect(); err != nil {
+		t.Fatalf("Connect returned %v; want nil", err)
+	}
+
+	for i := 0; i < 4; i++ {
+		if _, err := commitMessages(&pool, 1); err != nil {
+			t.Errorf("Commit returned %v; want nil", err)
+		}
+	}
+	_ = pool.Disconnect()
+
+	if len(firstReceived()) != 2 || len(secondReceived()) != 2 {
+		t.Errorf("Servers received %v and %v messages; want 2 and 2", len(firstReceived()), len(secondReceived()))
+	}
+}
+
+// TestPoolFailoverSkipsDeadEndpoint: Uses a failover pool with a primary endpoint no server listens on.
+// Checks that the batch is committed to the secondary and the primary is reported unhealthy.
+func TestPoolFailoverSkipsDeadEndpoint(t *testing.T) {
+	secondary, _, received := collectingServer(t, serverOptions{listen: true})
+	defer secondary.Close()
+	pool := RelpPool.RelpPool{
+		Endpoints: []RelpPool.Endpoint{deadEndpoint(t), serverEndpoint(secondary.Addr())},
+		Strategy:  RelpPool.STRATEGY_FAILOVER,
+	}
+	pool.Init()
+	if err := pool.Connect(); err != nil {
+		t.Fatalf("Connect returned %v; want nil", err)
+	}
+
+	batch, err := commitMessages(&pool, 3)
+	_ = pool.Disconnect()
+
+	status := pool.Status()
+	var connErr *Errors.ConnectionEstablishmentError
+	if err != nil || !batch.VerifyTransactionAll() || len(received()) != 3 {
+		t.Errorf("Commit returned %v and secondary received %v messages; want nil and 3", err, len(received()))
+	}
+	if status[0].Healthy || !errors.As(status[0].LastError, &connErr) || !status[1].Healthy {
+		t.Errorf("Status was %+v; want primary unhealthy with ConnectionEstablishmentError", status)
+	}
+}
+
+// TestPoolFailsOverUnacknowledged: Closes the primary server of a connected failover pool, and commits a batch.
+// Checks that the requests are committed to the secondary.
+func TestPoolFailsOverUnacknowledged(t *testing.T) {
+	primary, _, primaryReceived := collectingServer(t, serverOptions{listen: true})
+	secondary, _, secondaryReceived := collectingServer(t, serverOptions{listen: true})
+	defer secondary.Close()
+	pool := RelpPool.RelpPool{
+		Endpoints: []RelpPool.Endpoint{serverEndpoint(primary.Addr()), serverEndpoint(secondary.Addr())},
+		Strategy:  RelpPool.STRATEGY_FAILOVER,
+	}
+	pool.Init()
+	if err := pool.Connect(); err != nil {
+		t.Fatalf("Connect returned %v; want nil", err)
+	}
+	_ = primary.Close()
+
+	batch, err := commitMessages(&pool, 5)
+	_ = pool.Disconnect()
+
+	if err != nil || !batch.VerifyTransactionAll() {
+		t.Errorf("Commit returned %v; want nil and all transactions verified", err)
+	}
+	if len(primaryReceived()) != 0 || len(secondaryReceived()) != 5 {
+		t.Errorf("Servers received %v and %v messages; want 0 and 5", len(primaryReceived()), len(secondaryReceived()))
+	}
+	if pool.Status()[0].Healthy {
+		t.Errorf("Primary was healthy; want unhealthy")
+	}
+}
+
+// TestPoolReconnectsAfterServerClose: Restarts the server of a connected single endpoint pool, closing the idle
+// connection with serverclose, and commits a batch.
+// Checks that the batch was committed to the restarted server, and the endpoint stayed healthy.
+func TestPoolReconnectsAfterServerClose(t *testing.T) {
+	relpServer, _, received := collectingServer(t, serverOptions{listen: true})
+	port := relpServer.Addr().(*net.TCPAddr).Port
+	pool := RelpPool.RelpPool{Endpoints: []RelpPool.Endpoint{serverEndpoint(relpServer.Addr())}}
+	pool.Init()
+	if err := pool.Connect(); err != nil {
+		t.Fatalf("Connect returned %v; want nil", err)
+	}
+	_ = relpServer.Close()
+	if err := relpServer.Listen("127.0.0.1", port); err != nil {
+		t.Fatalf("Could not restart server: %v", err)
+	}
+	defer relpServer.Close()
+
+	batch, err := commitMessages(&pool, 2)
+	_ = pool.Disconnect()
+
+	if err != nil || !batch.VerifyTransactionAll() || len(received()) != 2 {
+		t.Errorf("Commit returned %v with %v messages received; want nil and 2", err, len(received()))
+	}
+	if status := pool.Status()[0]; !status.Healthy || status.Failures != 0 {
+		t.Errorf("Status was %+v; want healthy without failures", status)
+	}
+}
+
+// TestPoolLeastPending: Commits a batch to a least-pending pool of two servers, the first of which does not answer
+// before released, and commits two more batches while the first one is pending.
+// Checks that both were committed to the second server, and the first batch to the first server once released.
+func TestPoolLeastPending(t *testing.T) {
+	blocked := make(chan struct{}, 1)
+	release := make(chan struct{})
+	first := &RelpServer.RelpServer{Handler: func(_ []byte) error {
+		blocked <- struct{}{}
+		<-release
+		return nil
+	}}
+	first.Init()
+	if err := first.Listen("127.0.0.1", 0); err != nil {
+		t.Fatalf("Could not start server: %v", err)
+	}
+	defer first.Close()
+	second, _, secondReceived := collectingServer(t, serverOptions{listen: true})
+	defer second.Close()
+	pool := RelpPool.RelpPool{
+		Endpoints: []RelpPool.Endpoint{serverEndpoint(first.Addr()), serverEndpoint(second.Addr())},
+		Strategy:  RelpPool.STRATEGY_LEAST_PENDING,
+	}
+	pool.Init()
+	if err := pool.Connect(); err != nil {
+		t.Fatalf("Connect returned %v; want nil", err)
+	}
+
+	pendingErr := make(chan error, 1)
+	go func() {
+		_, err := commitMessages(&pool, 1)
+		pendingErr <- err
+	}()
+	<-blocked
+	_, firstErr := commitMessages(&pool, 1)
+	_, secondErr := commitMessages(&pool, 1)
+	received := len(secondReceived())
+	close(release)
+	err := <-pendingErr
+	_ = pool.Disconnect()
+
+	if firstErr != nil || secondErr != nil || received != 2 {
+		t.Errorf("Commits returned %v and %v with %v messages received by the second server; want nil, nil and 2",
+			firstErr, secondErr, received)
+	}
+	if err != nil {
+		t.Errorf("Pending commit returned %v; want nil", err)
+	}
+}
+
+// TestPoolWithoutHealthyEndpoints: Uses a pool with no servers listening.
+// Checks that Connect and Commit return NoHealthyEndpointError wrapping the connection error.
+func TestPoolWithoutHealthyEndpoints(t *testing.T) {
+	pool := RelpPool.RelpPool{Endpoints: []RelpPool.Endpoint{deadEndpoint(t), deadEndpoint(t)}}
+	pool.Init()
+	connectErr := pool.Connect()
+	_, commitErr := commitMessages(&pool, 1)
+
+	var noHealthyErr *Errors.NoHealthyEndpointError
+	var connErr *Errors.ConnectionEstablishmentError
+	if !errors.As(connectErr, &noHealthyErr) || !errors.As(connectErr, &connErr) {
+		t.Errorf("Connect returned %v; want NoHealthyEndpointError wrapping ConnectionEstablishmentError", connectErr)
+	}
+	if !errors.As(commitErr, &noHealthyErr) {
+		t.Errorf("Commit returned %v; want NoHealthyEndpointError", commitErr)
+	}
+}
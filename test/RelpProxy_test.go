@@ -0,0 +1,220 @@
+package test
+
+import (
+	"bufio"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/teragrep/rlp_05/pkg/Errors"
+	"github.com/teragrep/rlp_05/pkg/RelpBatch"
+	"github.com/teragrep/rlp_05/pkg/RelpConnection"
+	"github.com/teragrep/rlp_05/pkg/RelpDialer"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+)
+
+// startProxy accepts connections on a local port, and tunnels each to the target returned by the handshake.
+// A handshake returning an empty target closes the connection. The targets are sent to the returned channel.
+func startProxy(t *testing.T, handshake func(conn net.Conn, reader *bufio.Reader) string) (net.Listener, chan string) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Could not start proxy: %v", err)
+	}
+	targets := make(chan string, 10)
+	go func() {
+		for {
+			conn, acceptErr := listener.Accept()
+			if acceptErr != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				reader := bufio.NewReader(conn)
+				target := handshake(conn, reader)
+				if target == "" {
+					return
+				}
+				targets <- target
+				upstream, dialErr := net.Dial("tcp", target)
+				if dialErr != nil {
+					return
+				}
+				defer upstream.Close()
+				go func() {
+					_, _ = io.Copy(conn, upstream)
+					_ = conn.Close()
+				}()
+				_, _ = io.Copy(upstream, reader)
+			}()
+		}
+	}()
+	return listener, targets
+}
+
+// httpConnectHandshake answers CONNECT requests with the given Proxy-Authorization, or any if empty
+func httpConnectHandshake(authorization string) func(conn net.Conn, reader *bufio.Reader) string {
+	return func(conn net.Conn, reader *bufio.Reader) string {
+		request, err := http.ReadRequest(reader)
+		if err != nil || request.Method != http.MethodConnect {
+			return ""
+		}
+		if authorization != "" && request.Header.Get("Proxy-Authorization") != authorization {
+			_, _ = conn.Write([]byte("HTTP/1.1 407 Proxy Authentication Required\r\n\r\n"))
+			return ""
+		}
+		_, _ = conn.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\n"))
+		return request.RequestURI
+	}
+}
+
+// socks5Handshake answers SOCKS5 CONNECT requests authenticated with the given username and password
+func socks5Handshake(username, password string) func(conn net.Conn, reader *bufio.Reader) string {
+	return func(conn net.Conn, reader *bufio.Reader) string {
+		greeting := make([]byte, 2)
+		if _, err := io.ReadFull(reader, greeting); err != nil {
+			return ""
+		}
+		_, _ = io.ReadFull(reader, make([]byte, greeting[1]))
+		_, _ = conn.Write([]byte{5, 2})
+
+		auth := make([]byte, 2)
+		_, _ = io.ReadFull(reader, auth)
+		user := make([]byte, auth[1])
+		_, _ = io.ReadFull(reader, user)
+		passLen, _ := reader.ReadByte()
+		pass := make([]byte, passLen)
+		_, _ = io.ReadFull(reader, pass)
+		if string(user) != username || string(pass) != password {
+			_, _ = conn.Write([]byte{1, 1})
+			return ""
+		}
+		_, _ = conn.Write([]byte{1, 0})
+
+		request := make([]byte, 4)
+		_, _ = io.ReadFull(reader, request)
+		var host string
+		switch request[3] {
+		case 1:
+			ip := make([]byte, 4)
+			_, _ = io.ReadFull(reader, ip)
+			host = net.IP(ip).String()
+		case 3:
+			length, _ := reader.ReadByte()
+			name := make([]byte, length)
+			_, _ = io.ReadFull(reader, name)
+			host = string(name)
+		default:
+			return ""
+		}
+		port := make([]byte, 2)
+		_, _ = io.ReadFull(reader, port)
+		_, _ = conn.Write([]byte{5, 0, 0, 1, 127, 0, 0, 1, 0, 0})
+		return net.JoinHostPort(host, fmt.Sprint(binary.BigEndian.Uint16(port)))
+	}
+}
+
+// commitOne commits a single message over the connection
+func commitOne(sess *RelpConnection.RelpConnection) error {
+	batch := RelpBatch.RelpBatch{}
+	batch.Init()
+	batch.Insert([]byte("HelloThisIsAMessage"))
+	return sess.Commit(&batch)
+}
+
+// TestHTTPProxy: Sends a message through an HTTP CONNECT proxy requiring basic authentication.
+// Checks that the proxy was asked for the server's address and the message was received.
+func TestHTTPProxy(t *testing.T) {
+	relpServer, _, received := collectingServer(t, serverOptions{listen: true})
+	defer relpServer.Close()
+	listener, targets := startProxy(t, httpConnectHandshake("Basic dXNlcjpzZWNyZXQ="))
+	defer listener.Close()
+	proxy := &RelpDialer.RelpHTTPProxy{Address: listener.Addr().String(), Username: "user", Password: "secret"}
+
+	sess := RelpConnection.RelpConnection{RelpDialer: &RelpDialer.RelpPlainDialer{DialFunc: proxy.DialContext}}
+	sess.Init()
+	ok, err := sess.Connect("127.0.0.1", relpServer.Addr().(*net.TCPAddr).Port)
+	if !ok || err != nil {
+		t.Fatalf("Connection was not successful! (success=%v, err=%v); want true", ok, err)
+	}
+	commitErr := commitOne(&sess)
+	sess.Disconnect()
+
+	if target := <-targets; target != relpServer.Addr().String() {
+		t.Errorf("Proxy was asked for %v; want %v", target, relpServer.Addr())
+	}
+	if commitErr != nil || len(received()) != 1 {
+		t.Errorf("Commit returned %v and server received %v messages; want nil and 1", commitErr, len(received()))
+	}
+}
+
+// TestHTTPProxyRejects: Connects through an HTTP CONNECT proxy with the wrong password.
+// Checks that Connect fails with ProxyError.
+func TestHTTPProxyRejects(t *testing.T) {
+	listener, _ := startProxy(t, httpConnectHandshake("Basic dXNlcjpzZWNyZXQ="))
+	defer listener.Close()
+	proxy := &RelpDialer.RelpHTTPProxy{Address: listener.Addr().String(), Username: "user", Password: "wrong"}
+
+	sess := RelpConnection.RelpConnection{RelpDialer: &RelpDialer.RelpPlainDialer{DialFunc: proxy.DialContext}}
+	sess.Init()
+	ok, err := sess.Connect("127.0.0.1", 1601)
+
+	var proxyErr *Errors.ProxyError
+	if ok || !errors.As(err, &proxyErr) {
+		t.Errorf("Connect returned (%v, %v); want false and ProxyError", ok, err)
+	}
+}
+
+// TestSOCKS5ProxyWithTLS: Sends a message over TLS through a SOCKS5 proxy requiring authentication.
+// Checks that the server certificate was verified and the message was received.
+func TestSOCKS5ProxyWithTLS(t *testing.T) {
+	certificate := selfSignedCertificate()
+	relpServer, _, received := collectingServer(t, serverOptions{})
+	if err := relpServer.ListenTLS("127.0.0.1", 0, &tls.Config{Certificates: []tls.Certificate{certificate}}); err != nil {
+		t.Fatalf("Could not start server: %v", err)
+	}
+	defer relpServer.Close()
+	listener, targets := startProxy(t, socks5Handshake("user", "secret"))
+	defer listener.Close()
+	proxy := &RelpDialer.RelpSOCKS5Proxy{Address: listener.Addr().String(), Username: "user", Password: "secret"}
+	roots := x509.NewCertPool()
+	parsed, _ := x509.ParseCertificate(certificate.Certificate[0])
+	roots.AddCert(parsed)
+
+	sess := RelpConnection.RelpConnection{RelpDialer: &RelpDialer.RelpTLSDialer{DialFunc: proxy.DialContext}}
+	sess.Init()
+	sess.TlsConfig = &tls.Config{RootCAs: roots}
+	ok, err := sess.Connect("127.0.0.1", relpServer.Addr().(*net.TCPAddr).Port)
+	if !ok || err != nil {
+		t.Fatalf("Connection was not successful! (success=%v, err=%v); want true", ok, err)
+	}
+	commitErr := commitOne(&sess)
+	sess.Disconnect()
+
+	if target := <-targets; target != relpServer.Addr().String() {
+		t.Errorf("Proxy was asked for %v; want %v", target, relpServer.Addr())
+	}
+	if commitErr != nil || len(received()) != 1 {
+		t.Errorf("Commit returned %v and server received %v messages; want nil and 1", commitErr, len(received()))
+	}
+}
+
+// TestSOCKS5ProxyRejects: Connects through a SOCKS5 proxy with the wrong password.
+// Checks that Connect fails with ProxyError.
+func TestSOCKS5ProxyRejects(t *testing.T) {
+	listener, _ := startProxy(t, socks5Handshake("user", "secret"))
+	defer listener.Close()
+	proxy := &RelpDialer.RelpSOCKS5Proxy{Address: listener.Addr().String(), Username: "user", Password: "wrong"}
+
+	sess := RelpConnection.RelpConnection{RelpDialer: &RelpDialer.RelpPlainDialer{DialFunc: proxy.DialContext}}
+	sess.Init()
+	ok, err := sess.Connect("collector.example.test", 1601)
+
+	var proxyErr *Errors.ProxyError
+	if ok || !errors.As(err, &proxyErr) {
+		t.Errorf("Connect returned (%v, %v); want false and ProxyError", ok, err)
+	}
+}
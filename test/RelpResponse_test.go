@@ -0,0 +1,45 @@
+package test
+
+import (
+	"errors"
+	"github.com/teragrep/rlp_05/internal/RelpFrame"
+	"github.com/teragrep/rlp_05/pkg/Errors"
+	"strings"
+	"testing"
+)
+
+// TestParseResponse: Parses the data of rsp frames with and without text and data lines.
+// Checks the code, text and lines of each, including a code without a trailing SP.
+func TestParseResponse(t *testing.T) {
+	cases := []struct {
+		data  string
+		code  int
+		text  string
+		lines string
+	}{
+		{"200 OK", 200, "OK", ""},
+		{"200", 200, "", ""},
+		{"500 message not accepted", 500, "message not accepted", ""},
+		{"200 OK\nrelp_version=0\ncommands=syslog\n", 200, "OK", "relp_version=0,commands=syslog"},
+	}
+	for _, c := range cases {
+		rx := RelpFrame.RX{Frame: RelpFrame.Frame{Data: []byte(c.data), DataLength: len(c.data)}}
+		response, err := rx.ParseResponse()
+		if err != nil || response.Code != c.code || response.Text != c.text || strings.Join(response.Lines, ",") != c.lines {
+			t.Errorf("Parsing %q returned %+v and %v; want code %v, text %q and lines %q", c.data, response, err, c.code, c.text, c.lines)
+		}
+	}
+}
+
+// TestParseInvalidResponse: Parses the data of rsp frames with missing, non-numeric, too long and out of range codes.
+// Checks that each returns ResponseCodeParsingError.
+func TestParseInvalidResponse(t *testing.T) {
+	for _, data := range []string{"", " OK", "2x0 OK", "2000 OK", "20 OK", "099 OK", "600 OK"} {
+		rx := RelpFrame.RX{Frame: RelpFrame.Frame{Data: []byte(data), DataLength: len(data)}}
+		_, err := rx.ParseResponse()
+		var codeErr *Errors.ResponseCodeParsingError
+		if !errors.As(err, &codeErr) {
+			t.Errorf("Parsing %q returned %v; want ResponseCodeParsingError", data, err)
+		}
+	}
+}
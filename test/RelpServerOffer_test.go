@@ -0,0 +1,107 @@
+package test
+
+import (
+	"errors"
+	"fmt"
+	"github.com/teragrep/rlp_05/pkg/Errors"
+	"github.com/teragrep/rlp_05/pkg/RelpConnection"
+	"github.com/teragrep/rlp_05/pkg/RelpDialer"
+	"github.com/teragrep/rlp_05/pkg/RelpServer"
+	"net"
+	"testing"
+)
+
+// offeringServer starts a server responding to the open command with the given offer, and sending
+// the received open request to the returned channel
+func offeringServer(t *testing.T, offer string) (net.Listener, chan string) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Could not start server: %v", err)
+	}
+	received := make(chan string, 1)
+	go func() {
+		conn, acceptErr := listener.Accept()
+		if acceptErr != nil {
+			return
+		}
+		defer conn.Close()
+		buf := make([]byte, 512)
+		n, _ := conn.Read(buf)
+		received <- string(buf[:n])
+		data := "200 OK\n" + offer
+		_, _ = conn.Write([]byte(fmt.Sprintf("1 rsp %v %v\n", len(data), data)))
+		_, _ = conn.Read(buf)
+	}()
+	return listener, received
+}
+
+// TestServerOfferIsParsed: Connects to a server with a custom relp_software.
+// Checks that the offer of the server is available on the connection.
+func TestServerOfferIsParsed(t *testing.T) {
+	relpServer := RelpServer.RelpServer{Software: "test-server"}
+	relpServer.Init()
+	if err := relpServer.Listen("127.0.0.1", 0); err != nil {
+		t.Fatalf("Could not start server: %v", err)
+	}
+	defer relpServer.Close()
+
+	sess := RelpConnection.RelpConnection{RelpDialer: &RelpDialer.RelpPlainDialer{}}
+	sess.Init()
+	ok, err := sess.Connect("127.0.0.1", relpServer.Addr().(*net.TCPAddr).Port)
+	if !ok || err != nil {
+		t.Fatalf("Connection was not successful! (success=%v, err=%v); want true", ok, err)
+	}
+	defer sess.Disconnect()
+
+	offer := sess.ServerOffer
+	if offer == nil || offer.Version != 0 || offer.Software != "test-server" || !offer.SupportsCommand("syslog") {
+		t.Errorf("ServerOffer was %+v; want version 0, software test-server and syslog command", offer)
+	}
+}
+
+// TestCustomOffer: Connects with a custom relp_software and an additional command.
+// Checks that they are sent in the open command.
+func TestCustomOffer(t *testing.T) {
+	listener, received := offeringServer(t, "relp_version=0\nrelp_software=fake\ncommands=syslog\n")
+	defer listener.Close()
+
+	sess := RelpConnection.RelpConnection{RelpDialer: &RelpDialer.RelpPlainDialer{}}
+	sess.Init()
+	sess.Software = "my-app,1.0.0,https://example.com"
+	sess.Commands = []string{"starttls"}
+	ok, err := sess.Connect("127.0.0.1", listener.Addr().(*net.TCPAddr).Port)
+	sess.TearDown()
+
+	want := "1 open 88 \nrelp_version=0\nrelp_software=my-app,1.0.0,https://example.com\ncommands=syslog,starttls\n\n"
+	if request := <-received; request != want {
+		t.Errorf("Open request was %q; want %q", request, want)
+	}
+	if !ok || err != nil {
+		t.Errorf("Connection was not successful! (success=%v, err=%v); want true", ok, err)
+	}
+}
+
+// TestIncompatibleOffer: Connects to servers offering no syslog command or another relp_version.
+// Checks that Connect fails with OfferNegotiationError.
+func TestIncompatibleOffer(t *testing.T) {
+	offers := []string{
+		"relp_version=0\nrelp_software=fake\ncommands=other\n",
+		"relp_version=1\nrelp_software=fake\ncommands=syslog\n",
+		"relp_software=fake\ncommands=syslog\n",
+	}
+	for _, offer := range offers {
+		listener, _ := offeringServer(t, offer)
+		sess := RelpConnection.RelpConnection{RelpDialer: &RelpDialer.RelpPlainDialer{}}
+		sess.Init()
+		ok, err := sess.Connect("127.0.0.1", listener.Addr().(*net.TCPAddr).Port)
+		_ = listener.Close()
+
+		var offerErr *Errors.OfferNegotiationError
+		if ok || !errors.As(err, &offerErr) {
+			t.Errorf("Connect with offer %q returned (%v, %v); want false and OfferNegotiationError", offer, ok, err)
+		}
+		if _, commitErr := sess.Disconnect(); commitErr == nil {
+			t.Errorf("Disconnect was successful after a failed negotiation; want InvalidStateError")
+		}
+	}
+}
@@ -0,0 +1,223 @@
+package test
+
+import (
+	"errors"
+	"fmt"
+	"github.com/teragrep/rlp_05/pkg/RelpBatch"
+	"github.com/teragrep/rlp_05/pkg/RelpConnection"
+	"github.com/teragrep/rlp_05/pkg/RelpDialer"
+	"github.com/teragrep/rlp_05/pkg/RelpServer"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestServerDeliversPayloads: Sends two syslog messages to a RelpServer.
+// Checks that the handler received both payloads in order and that the batch was verified.
+func TestServerDeliversPayloads(t *testing.T) {
+	var mutex sync.Mutex
+	var received []string
+	relpServer := RelpServer.RelpServer{Handler: func(payload []byte) error {
+		mutex.Lock()
+		received = append(received, string(payload))
+		mutex.Unlock()
+		return nil
+	}}
+	relpServer.Init()
+	if err := relpServer.Listen("127.0.0.1", 0); err != nil {
+		t.Fatalf("Could not start server: %v", err)
+	}
+	defer relpServer.Close()
+
+	sess := RelpConnection.RelpConnection{RelpDialer: &RelpDialer.RelpPlainDialer{}}
+	sess.Init()
+	ok, _ := sess.Connect("127.0.0.1", relpServer.Addr().(*net.TCPAddr).Port)
+	if !ok {
+		t.Fatalf("Connection was not successful! (success=%v); want true", ok)
+	}
+
+	msgBatch := RelpBatch.RelpBatch{}
+	msgBatch.Init()
+	msgBatch.Insert([]byte("first"))
+	msgBatch.Insert([]byte("second"))
+	err := sess.Commit(&msgBatch)
+	if err != nil {
+		t.Errorf("Error committing batch (err!=nil); want nil")
+	}
+	if !msgBatch.VerifyTransactionAll() {
+		t.Errorf("Batch could not be verified! (verified=false); want true")
+	}
+	if ok, _ := sess.Disconnect(); !ok {
+		t.Errorf("Disconnection was not successful! (success=false); want true")
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	if len(received) != 2 || received[0] != "first" || received[1] != "second" {
+		t.Errorf("Handler received %v; want [first second]", received)
+	}
+}
+
+// TestServerRejectsPayload: Sends a syslog message to a RelpServer whose handler rejects it.
+// Checks that the rejected transaction could not be verified.
+func TestServerRejectsPayload(t *testing.T) {
+	relpServer := RelpServer.RelpServer{Handler: func(_ []byte) error {
+		return errors.New("rejected")
+	}}
+	relpServer.Init()
+	if err := relpServer.Listen("127.0.0.1", 0); err != nil {
+		t.Fatalf("Could not start server: %v", err)
+	}
+	defer relpServer.Close()
+
+	sess := RelpConnection.RelpConnection{RelpDialer: &RelpDialer.RelpPlainDialer{}}
+	sess.Init()
+	ok, _ := sess.Connect("127.0.0.1", relpServer.Addr().(*net.TCPAddr).Port)
+	if !ok {
+		t.Fatalf("Connection was not successful! (success=%v); want true", ok)
+	}
+
+	msgBatch := RelpBatch.RelpBatch{}
+	msgBatch.Init()
+	reqId := msgBatch.Insert([]byte("message"))
+	err := sess.Commit(&msgBatch)
+	if err != nil {
+		t.Errorf("Error committing batch (err!=nil); want nil")
+	}
+	if msgBatch.VerifyTransaction(reqId) {
+		t.Errorf("Rejected transaction was verified! (verified=true); want false")
+	}
+	sess.Disconnect()
+}
+
+// TestServerRejectionWithLineBreaks: Sends a syslog message to a RelpServer whose handler rejects it with
+// an error spanning several lines.
+// Checks that the rejection text is received on a single line.
+func TestServerRejectionWithLineBreaks(t *testing.T) {
+	relpServer := RelpServer.RelpServer{Handler: func(_ []byte) error {
+		return errors.New("first line\nsecond line\r\nthird line")
+	}}
+	relpServer.Init()
+	if err := relpServer.Listen("127.0.0.1", 0); err != nil {
+		t.Fatalf("Could not start server: %v", err)
+	}
+	defer relpServer.Close()
+
+	sess := RelpConnection.RelpConnection{RelpDialer: &RelpDialer.RelpPlainDialer{}}
+	sess.Init()
+	ok, _ := sess.Connect("127.0.0.1", relpServer.Addr().(*net.TCPAddr).Port)
+	if !ok {
+		t.Fatalf("Connection was not successful! (success=%v); want true", ok)
+	}
+	msgBatch := RelpBatch.RelpBatch{}
+	msgBatch.Init()
+	reqId := msgBatch.Insert([]byte("message"))
+	err := sess.Commit(&msgBatch)
+	sess.Disconnect()
+
+	result, _ := msgBatch.Result(reqId)
+	if err != nil || result.Status != RelpBatch.RESULT_REJECTED || result.Text != "first line second line third line" {
+		t.Errorf("Commit returned %v with result %+v; want nil and rejected with the text on a single line", err, result)
+	}
+}
+
+// TestServerClosesOnOversizedFrame: Sends an OPEN frame with a length exceeding the MaxFrameSize of a RelpServer,
+// and connects another client afterwards.
+// Checks that the first client is sent serverclose and disconnected, and that the server still serves the other.
+func TestServerClosesOnOversizedFrame(t *testing.T) {
+	relpServer := RelpServer.RelpServer{MaxFrameSize: 1024}
+	relpServer.Init()
+	if err := relpServer.Listen("127.0.0.1", 0); err != nil {
+		t.Fatalf("Could not start server: %v", err)
+	}
+	defer relpServer.Close()
+
+	for _, request := range []string{"1 open 9223372036854775807 x", "1 open 2048 x"} {
+		conn, err := net.Dial("tcp", relpServer.Addr().String())
+		if err != nil {
+			t.Fatalf("Could not connect: %v", err)
+		}
+		_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+		_, _ = conn.Write([]byte(request))
+		response, readErr := io.ReadAll(conn)
+		_ = conn.Close()
+		if readErr != nil || !strings.Contains(string(response), "serverclose") {
+			t.Errorf("Request %q was answered with %q and %v; want serverclose and EOF", request, response, readErr)
+		}
+	}
+
+	sess := RelpConnection.RelpConnection{RelpDialer: &RelpDialer.RelpPlainDialer{}}
+	sess.Init()
+	ok, err := sess.Connect("127.0.0.1", relpServer.Addr().(*net.TCPAddr).Port)
+	sess.Disconnect()
+	if !ok {
+		t.Errorf("Connect returned (%v, %v) after the oversized frames; want true", ok, err)
+	}
+}
+
+// TestServerClosesOnReadTimeout: Starts an OPEN frame without completing it, on a RelpServer with a short
+// ReadTimeout. Checks that the client is sent serverclose and disconnected.
+func TestServerClosesOnReadTimeout(t *testing.T) {
+	relpServer := RelpServer.RelpServer{ReadTimeout: 100 * time.Millisecond}
+	relpServer.Init()
+	if err := relpServer.Listen("127.0.0.1", 0); err != nil {
+		t.Fatalf("Could not start server: %v", err)
+	}
+	defer relpServer.Close()
+	conn, err := net.Dial("tcp", relpServer.Addr().String())
+	if err != nil {
+		t.Fatalf("Could not connect: %v", err)
+	}
+	defer conn.Close()
+
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+	_, _ = conn.Write([]byte("1 open 10 relp_"))
+	response, readErr := io.ReadAll(conn)
+
+	if readErr != nil || !strings.Contains(string(response), "serverclose") {
+		t.Errorf("Incomplete frame was answered with %q and %v; want serverclose and EOF", response, readErr)
+	}
+}
+
+// TestServerCloseWithoutReadingClient: Sends syslog messages to a RelpServer answering with long rejections,
+// from a client which never reads the responses, and closes the server while it is blocked writing.
+// Checks that Close returns after the CloseTimeout instead of blocking.
+func TestServerCloseWithoutReadingClient(t *testing.T) {
+	rejection := errors.New(strings.Repeat("x", 64*1024))
+	relpServer := RelpServer.RelpServer{Handler: func(_ []byte) error {
+		return rejection
+	}, CloseTimeout: 100 * time.Millisecond}
+	relpServer.Init()
+	if err := relpServer.Listen("127.0.0.1", 0); err != nil {
+		t.Fatalf("Could not start server: %v", err)
+	}
+	conn, err := net.Dial("tcp", relpServer.Addr().String())
+	if err != nil {
+		t.Fatalf("Could not connect: %v", err)
+	}
+	defer conn.Close()
+	go func() {
+		_ = conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+		_, _ = conn.Write([]byte("1 open 0 \n"))
+		for txnId := 2; ; txnId++ {
+			if _, writeErr := conn.Write([]byte(fmt.Sprintf("%v syslog 1 x\n", txnId))); writeErr != nil {
+				return
+			}
+		}
+	}()
+	time.Sleep(500 * time.Millisecond)
+
+	closed := make(chan struct{})
+	go func() {
+		_ = relpServer.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Errorf("Close did not return within 5 seconds; want it to give up writing after the CloseTimeout")
+	}
+}
@@ -0,0 +1,246 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"github.com/teragrep/rlp_05/pkg/RelpBatch"
+	"github.com/teragrep/rlp_05/pkg/RelpConnection"
+	"github.com/teragrep/rlp_05/pkg/RelpDialer"
+	"github.com/teragrep/rlp_05/pkg/RelpServer"
+	"github.com/teragrep/rlp_05/pkg/RelpSpool"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestSpoolReplaysUnacknowledged: Appends three messages, acknowledges the first one and reopens the spool.
+// Checks that the two unacknowledged messages are replayed in order.
+func TestSpoolReplaysUnacknowledged(t *testing.T) {
+	dir := t.TempDir()
+	spool := RelpSpool.RelpSpool{Directory: dir}
+	if err := spool.Init(); err != nil {
+		t.Fatalf("Could not init spool: %v", err)
+	}
+	seqs, _ := spool.Append([]byte("first"), []byte("second"), []byte("third"))
+	_ = spool.Acknowledge(seqs[0])
+	_ = spool.Close()
+
+	reopened := RelpSpool.RelpSpool{Directory: dir}
+	if err := reopened.Init(); err != nil {
+		t.Fatalf("Could not reopen spool: %v", err)
+	}
+	defer reopened.Close()
+	batch := RelpBatch.RelpBatch{}
+	batch.Init()
+	replayed, err := reopened.Replay(&batch)
+
+	if err != nil || replayed != 2 {
+		t.Fatalf("Replayed %v message(s) with error %v; want 2 and nil", replayed, err)
+	}
+	first, _ := batch.GetRequest(1)
+	second, _ := batch.GetRequest(2)
+	if string(first.Data) != "second" || string(second.Data) != "third" {
+		t.Errorf("Replayed %q and %q; want \"second\" and \"third\"", first.Data, second.Data)
+	}
+}
+
+// TestSpoolDeletesAcknowledgedSegments: Appends three messages into separate segments and acknowledges them all.
+// Checks that only the segment being appended to is left on disk.
+func TestSpoolDeletesAcknowledgedSegments(t *testing.T) {
+	dir := t.TempDir()
+	spool := RelpSpool.RelpSpool{Directory: dir, MaxSegmentSize: 1}
+	if err := spool.Init(); err != nil {
+		t.Fatalf("Could not init spool: %v", err)
+	}
+	defer spool.Close()
+	var seqs []uint64
+	for _, msg := range []string{"first", "second", "third"} {
+		seq, _ := spool.Append([]byte(msg))
+		seqs = append(seqs, seq...)
+	}
+
+	err := spool.Acknowledge(seqs...)
+
+	segments, _ := filepath.Glob(filepath.Join(dir, "segment-*.log"))
+	if err != nil || len(segments) != 1 {
+		t.Errorf("Got %v segment(s) with error %v; want 1 and nil", len(segments), err)
+	}
+}
+
+// TestSpoolTruncatesPartialRecord: Appends two messages and garbage simulating a crash during a write.
+// Checks that the reopened spool replays the two messages and keeps appending after them.
+func TestSpoolTruncatesPartialRecord(t *testing.T) {
+	dir := t.TempDir()
+	spool := RelpSpool.RelpSpool{Directory: dir}
+	if err := spool.Init(); err != nil {
+		t.Fatalf("Could not init spool: %v", err)
+	}
+	_, _ = spool.Append([]byte("first"), []byte("second"))
+	_ = spool.Close()
+	segments, _ := filepath.Glob(filepath.Join(dir, "segment-*.log"))
+	file, _ := os.OpenFile(segments[0], os.O_WRONLY|os.O_APPEND, 0o600)
+	_, _ = file.Write([]byte{0, 0, 0, 0, 0, 0, 0, 3, 0, 0})
+	_ = file.Close()
+
+	reopened := RelpSpool.RelpSpool{Directory: dir}
+	if err := reopened.Init(); err != nil {
+		t.Fatalf("Could not reopen spool: %v", err)
+	}
+	defer reopened.Close()
+	seqs, _ := reopened.Append([]byte("third"))
+	batch := RelpBatch.RelpBatch{}
+	batch.Init()
+	replayed, err := reopened.Replay(&batch)
+
+	if err != nil || replayed != 3 || seqs[0] != 3 {
+		t.Errorf("Replayed %v message(s) with error %v and appended seq %v; want 3, nil and 3", replayed, err, seqs[0])
+	}
+}
+
+// TestSpoolReplaysInChunks: Appends five messages into separate segments, and replays them two at a time,
+// acknowledging the replayed messages except the fourth one.
+// Checks that each replay continues after the acknowledged messages, and that the fourth message is replayed again.
+func TestSpoolReplaysInChunks(t *testing.T) {
+	spool := RelpSpool.RelpSpool{Directory: t.TempDir(), MaxSegmentSize: 1}
+	if err := spool.Init(); err != nil {
+		t.Fatalf("Could not init spool: %v", err)
+	}
+	defer spool.Close()
+	for _, msg := range []string{"first", "second", "third", "fourth", "fifth"} {
+		_, _ = spool.Append([]byte(msg))
+	}
+
+	var replays []string
+	for i := 0; i < 3; i++ {
+		batch := RelpBatch.RelpBatch{}
+		batch.Init()
+		_, _ = spool.ReplayLimit(&batch, 2)
+		var payloads []string
+		for id := uint64(1); id <= batch.RequestId; id++ {
+			request, _ := batch.GetRequest(id)
+			seq, _ := batch.GetSpoolId(id)
+			payloads = append(payloads, string(request.Data))
+			if string(request.Data) != "fourth" {
+				_ = spool.Acknowledge(seq)
+			}
+		}
+		replays = append(replays, strings.Join(payloads, ","))
+	}
+
+	want := []string{"first,second", "third,fourth", "fourth,fifth"}
+	if strings.Join(replays, " ") != strings.Join(want, " ") {
+		t.Errorf("Replayed %q; want %q", replays, want)
+	}
+}
+
+// TestSpoolIgnoresCorruptLength: Appends a message and a record header with a length past the end of the file.
+// Checks that the reopened spool replays only the message.
+func TestSpoolIgnoresCorruptLength(t *testing.T) {
+	dir := t.TempDir()
+	spool := RelpSpool.RelpSpool{Directory: dir}
+	if err := spool.Init(); err != nil {
+		t.Fatalf("Could not init spool: %v", err)
+	}
+	_, _ = spool.Append([]byte("first"))
+	_ = spool.Close()
+	segments, _ := filepath.Glob(filepath.Join(dir, "segment-*.log"))
+	file, _ := os.OpenFile(segments[0], os.O_WRONLY|os.O_APPEND, 0o600)
+	_, _ = file.Write([]byte{0, 0, 0, 0, 0, 0, 0, 2, 0xff, 0xff, 0xff, 0xff, 0, 0, 0, 0})
+	_ = file.Close()
+
+	reopened := RelpSpool.RelpSpool{Directory: dir}
+	if err := reopened.Init(); err != nil {
+		t.Fatalf("Could not reopen spool: %v", err)
+	}
+	defer reopened.Close()
+	batch := RelpBatch.RelpBatch{}
+	batch.Init()
+	replayed, err := reopened.Replay(&batch)
+
+	if err != nil || replayed != 1 {
+		t.Errorf("Replayed %v message(s) with error %v; want 1 and nil", replayed, err)
+	}
+}
+
+// TestCommitWithSpool: Commits an accepted, a rejected and an unanswered message using a spool,
+// the server not answering the last one before the context times out.
+// Checks that only the unanswered message is left in the spool to be replayed.
+func TestCommitWithSpool(t *testing.T) {
+	release := make(chan struct{})
+	relpServer := RelpServer.RelpServer{Handler: func(payload []byte) error {
+		switch string(payload) {
+		case "rejected":
+			return errors.New("rejected")
+		case "unanswered":
+			<-release
+		}
+		return nil
+	}}
+	relpServer.Init()
+	if err := relpServer.Listen("127.0.0.1", 0); err != nil {
+		t.Fatalf("Could not start server: %v", err)
+	}
+	defer relpServer.Close()
+	defer close(release)
+
+	dir := t.TempDir()
+	spool := &RelpSpool.RelpSpool{Directory: dir}
+	if err := spool.Init(); err != nil {
+		t.Fatalf("Could not init spool: %v", err)
+	}
+	sess := RelpConnection.RelpConnection{RelpDialer: &RelpDialer.RelpPlainDialer{}}
+	sess.Init()
+	sess.Spool = spool
+	ok, _ := sess.Connect("127.0.0.1", relpServer.Addr().(*net.TCPAddr).Port)
+	if !ok {
+		t.Fatalf("Connection was not successful! (success=%v); want true", ok)
+	}
+	msgBatch := RelpBatch.RelpBatch{}
+	msgBatch.Init()
+	msgBatch.Insert([]byte("accepted"))
+	msgBatch.Insert([]byte("rejected"))
+	msgBatch.Insert([]byte("unanswered"))
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	_ = sess.CommitContext(ctx, &msgBatch)
+	sess.TearDown()
+	_ = spool.Close()
+
+	reopened := RelpSpool.RelpSpool{Directory: dir}
+	if err := reopened.Init(); err != nil {
+		t.Fatalf("Could not reopen spool: %v", err)
+	}
+	defer reopened.Close()
+	replayBatch := RelpBatch.RelpBatch{}
+	replayBatch.Init()
+	replayed, _ := reopened.Replay(&replayBatch)
+	request, _ := replayBatch.GetRequest(1)
+
+	if replayed != 1 || string(request.Data) != "unanswered" {
+		t.Errorf("Replayed %v message(s); want only the unanswered one", replayed)
+	}
+}
+
+// TestSpoolSkipsAcknowledged: Appends two messages and acknowledges the first one twice, then both of them.
+// Checks that the index gets a single range for each sequence number.
+func TestSpoolSkipsAcknowledged(t *testing.T) {
+	dir := t.TempDir()
+	spool := RelpSpool.RelpSpool{Directory: dir}
+	if err := spool.Init(); err != nil {
+		t.Fatalf("Could not init spool: %v", err)
+	}
+	defer spool.Close()
+	seqs, _ := spool.Append([]byte("first"), []byte("second"))
+
+	_ = spool.Acknowledge(seqs[0])
+	_ = spool.Acknowledge(seqs[0])
+	_ = spool.Acknowledge(seqs...)
+
+	index, err := os.ReadFile(filepath.Join(dir, RelpSpool.INDEX_FILE))
+	if err != nil || string(index) != "1 1\n2 2\n" {
+		t.Errorf("Index was %q with error %v; want \"1 1\\n2 2\\n\" and nil", index, err)
+	}
+}
@@ -0,0 +1,146 @@
+package test
+
+import (
+	"errors"
+	"github.com/teragrep/rlp_05/pkg/Errors"
+	"github.com/teragrep/rlp_05/pkg/RelpBatch"
+	"github.com/teragrep/rlp_05/pkg/RelpConnection"
+	"github.com/teragrep/rlp_05/pkg/RelpSyslog"
+	"testing"
+	"time"
+)
+
+// TestRfc5424Format: Formats a message with all the fields and structured data needing escaping.
+// Checks that the result matches the RFC 5424 format exactly.
+func TestRfc5424Format(t *testing.T) {
+	msg := RelpSyslog.Message{
+		Facility:  RelpSyslog.FACILITY_LOCAL4,
+		Severity:  RelpSyslog.SEVERITY_NOTICE,
+		Timestamp: time.Date(2003, 10, 11, 22, 14, 15, 3_123_456, time.FixedZone("", -7*3600)),
+		Hostname:  "mymachine.example.com",
+		AppName:   "evntslog",
+		ProcId:    "1234",
+		MsgId:     "ID47",
+		Msg:       "An application event log entry...",
+	}
+	msg.AddSDElement("exampleSDID@32473",
+		RelpSyslog.SDParam{Name: "iut", Value: "3"},
+		RelpSyslog.SDParam{Name: "eventSource", Value: `App"l\ication]`})
+	msg.AddSDElement("examplePriority@32473")
+
+	formatter := RelpSyslog.Rfc5424Formatter{}
+	formatted, err := formatter.Format(&msg)
+
+	want := `<165>1 2003-10-11T22:14:15.003123-07:00 mymachine.example.com evntslog 1234 ID47 ` +
+		`[exampleSDID@32473 iut="3" eventSource="App\"l\\ication\]"][examplePriority@32473] ` +
+		"\xef\xbb\xbfAn application event log entry..."
+	if err != nil || string(formatted) != want {
+		t.Errorf("Formatted %q with error %v; want %q", formatted, err, want)
+	}
+}
+
+// TestRfc5424FormatNilValues: Formats a message with only the priority set.
+// Checks that the empty fields are formatted as NILVALUE and no MSG is appended.
+func TestRfc5424FormatNilValues(t *testing.T) {
+	msg := RelpSyslog.Message{Facility: RelpSyslog.FACILITY_KERN, Severity: RelpSyslog.SEVERITY_EMERGENCY}
+	formatter := RelpSyslog.Rfc5424Formatter{}
+	formatted, err := formatter.Format(&msg)
+
+	if err != nil || string(formatted) != "<0>1 - - - - - -" {
+		t.Errorf("Formatted %q with error %v; want %q", formatted, err, "<0>1 - - - - - -")
+	}
+}
+
+// TestRfc5424InvalidFields: Formats messages with fields which can't be represented in the format.
+// Checks that each returns a SyslogFormatError naming the field, and nothing is inserted to the batch.
+func TestRfc5424InvalidFields(t *testing.T) {
+	tests := map[string]RelpSyslog.Message{
+		"SEVERITY": {Severity: 8},
+		"HOSTNAME": {Hostname: "my machine"},
+		"APP-NAME": {AppName: "an-app-name-which-is-longer-than-forty-eight-chars"},
+		"SD-ID":    {StructuredData: []RelpSyslog.SDElement{{Id: "id=1"}}},
+		"MSG":      {Msg: "\xff"},
+	}
+	batch := RelpBatch.RelpBatch{}
+	batch.Init()
+	formatter := RelpSyslog.Rfc5424Formatter{}
+	for field, msg := range tests {
+		_, err := formatter.Insert(&batch, &msg)
+		var formatErr *Errors.SyslogFormatError
+		if !errors.As(err, &formatErr) || formatErr.Field != field {
+			t.Errorf("Insert returned %v; want SyslogFormatError for field %v", err, field)
+		}
+	}
+	if batch.GetWorkQueueLen() != 0 {
+		t.Errorf("Batch had %v request(s); want 0", batch.GetWorkQueueLen())
+	}
+}
+
+// TestRfc3164Format: Formats a message with the formatter's defaults for hostname and time zone.
+// Checks that the result matches the RFC 3164 format, and that the SD and MSGID are left out.
+func TestRfc3164Format(t *testing.T) {
+	msg := RelpSyslog.Message{
+		Facility:  RelpSyslog.FACILITY_AUTH,
+		Severity:  RelpSyslog.SEVERITY_CRITICAL,
+		Timestamp: time.Date(1987, 10, 11, 22, 14, 15, 0, time.UTC),
+		AppName:   "su",
+		ProcId:    "42",
+		MsgId:     "ID47",
+		Msg:       "'su root' failed for lonvick on /dev/pts/8",
+	}
+	msg.AddSDElement("exampleSDID@32473")
+	formatter := RelpSyslog.Rfc3164Formatter{}
+	formatter.Init()
+	formatter.Hostname = "mymachine"
+	formatter.Location = time.FixedZone("", 2*3600)
+	formatted, err := formatter.Format(&msg)
+
+	want := "<34>Oct 12 00:14:15 mymachine su[42]: 'su root' failed for lonvick on /dev/pts/8"
+	if err != nil || string(formatted) != want {
+		t.Errorf("Formatted %q with error %v; want %q", formatted, err, want)
+	}
+}
+
+// TestRfc3164Truncation: Formats a message with a too long TAG and MSG ending in a multibyte character.
+// Checks that the TAG and the message are truncated without leaving a partial character.
+func TestRfc3164Truncation(t *testing.T) {
+	msg := RelpSyslog.Message{
+		Timestamp: time.Date(2024, 3, 1, 2, 3, 4, 0, time.UTC),
+		Hostname:  "host",
+		AppName:   "tag-which-is-longer-than-the-limit",
+		Msg:       "abcä",
+	}
+	formatter := RelpSyslog.Rfc3164Formatter{Location: time.UTC, MaxTagLength: 8, MaxLength: 38}
+	formatted, err := formatter.Format(&msg)
+
+	want := "<0>Mar  1 02:03:04 host tag-whic: abc"
+	if err != nil || string(formatted) != want {
+		t.Errorf("Formatted %q with error %v; want %q", formatted, err, want)
+	}
+}
+
+// TestConnectionFormatter: Inserts the same message using a connection with the default formatter and
+// one with Rfc3164Formatter selected. Checks that the payloads are in the format of each connection.
+func TestConnectionFormatter(t *testing.T) {
+	msg := RelpSyslog.Message{Facility: RelpSyslog.FACILITY_USER, Severity: RelpSyslog.SEVERITY_INFO,
+		Timestamp: time.Date(2024, 3, 1, 2, 3, 4, 0, time.UTC), Hostname: "host", AppName: "app", Msg: "hello"}
+	defaultConn := RelpConnection.RelpConnection{}
+	defaultConn.Init()
+	bsdConn := RelpConnection.RelpConnection{}
+	bsdConn.Init()
+	bsdConn.Formatter = &RelpSyslog.Rfc3164Formatter{Location: time.UTC}
+
+	batch := RelpBatch.RelpBatch{}
+	batch.Init()
+	firstId, firstErr := defaultConn.InsertMessage(&batch, &msg)
+	secondId, secondErr := bsdConn.InsertMessage(&batch, &msg)
+	first, _ := batch.GetRequest(firstId)
+	second, _ := batch.GetRequest(secondId)
+
+	if firstErr != nil || string(first.Data) != "<14>1 2024-03-01T02:03:04.000000Z host app - - - \xef\xbb\xbfhello" {
+		t.Errorf("Default formatter inserted %q with error %v; want RFC 5424", first.Data, firstErr)
+	}
+	if secondErr != nil || string(second.Data) != "<14>Mar  1 02:03:04 host app: hello" {
+		t.Errorf("Rfc3164Formatter inserted %q with error %v; want RFC 3164", second.Data, secondErr)
+	}
+}
@@ -0,0 +1,129 @@
+package test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"github.com/teragrep/rlp_05/pkg/Errors"
+	"github.com/teragrep/rlp_05/pkg/RelpConnection"
+	"github.com/teragrep/rlp_05/pkg/RelpDialer"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// writeCertificate writes a self-signed client certificate with the common name and its key as PEM files
+func writeCertificate(t *testing.T, certFile, keyFile, commonName string, notAfter time.Time) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Could not generate key: %v", err)
+	}
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-2 * time.Hour),
+		NotAfter:     notAfter,
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Could not create certificate: %v", err)
+	}
+	keyDer, _ := x509.MarshalECPrivateKey(key)
+	_ = os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600)
+	_ = os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0o600)
+}
+
+// TestTLSFilesReloadRotatedCertificate: Connects with a client certificate loaded by RelpTLSFiles, replaces the
+// certificate files and reconnects. Checks that the server verified the CA and received the rotated certificate.
+func TestTLSFilesReloadRotatedCertificate(t *testing.T) {
+	dir := t.TempDir()
+	serverCertificate := selfSignedCertificate()
+	caFile := filepath.Join(dir, "ca.pem")
+	_ = os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: serverCertificate.Certificate[0]}), 0o600)
+	certFile, keyFile := filepath.Join(dir, "client.pem"), filepath.Join(dir, "client.key")
+	writeCertificate(t, certFile, keyFile, "first", time.Now().Add(time.Hour))
+
+	var mutex sync.Mutex
+	var clientNames []string
+	relpServer, _, _ := collectingServer(t, serverOptions{})
+	err := relpServer.ListenTLS("127.0.0.1", 0, &tls.Config{
+		Certificates: []tls.Certificate{serverCertificate},
+		ClientAuth:   tls.RequireAnyClientCert,
+		VerifyConnection: func(state tls.ConnectionState) error {
+			mutex.Lock()
+			defer mutex.Unlock()
+			clientNames = append(clientNames, state.PeerCertificates[0].Subject.CommonName)
+			return nil
+		},
+	})
+	if err != nil {
+		t.Fatalf("Could not start server: %v", err)
+	}
+	defer relpServer.Close()
+
+	files := &RelpDialer.RelpTLSFiles{CertFile: certFile, KeyFile: keyFile, CAFile: caFile}
+	if initErr := files.Init(); initErr != nil {
+		t.Fatalf("Init returned %v; want nil", initErr)
+	}
+	sess := RelpConnection.RelpConnection{RelpDialer: &RelpDialer.RelpTLSDialer{}}
+	sess.Init()
+	sess.TlsConfig = files.Config()
+	port := relpServer.Addr().(*net.TCPAddr).Port
+	firstOk, firstErr := sess.Connect("127.0.0.1", port)
+	sess.Disconnect()
+
+	writeCertificate(t, certFile, keyFile, "second", time.Now().Add(time.Hour))
+	_ = os.Chtimes(certFile, time.Now().Add(time.Minute), time.Now().Add(time.Minute))
+	secondOk, secondErr := sess.Connect("127.0.0.1", port)
+	sess.Disconnect()
+
+	if !firstOk || !secondOk {
+		t.Fatalf("Connections were not successful! (errors %v and %v); want true", firstErr, secondErr)
+	}
+	mutex.Lock()
+	defer mutex.Unlock()
+	if len(clientNames) != 2 || clientNames[0] != "first" || clientNames[1] != "second" {
+		t.Errorf("Server received client certificates %v; want [first second]", clientNames)
+	}
+}
+
+// TestTLSFilesValidation: Initializes RelpTLSFiles with an expired certificate, a mismatching key and
+// a CA bundle without certificates. Checks that each returns TLSConfigError.
+func TestTLSFilesValidation(t *testing.T) {
+	dir := t.TempDir()
+	expiredCert, expiredKey := filepath.Join(dir, "expired.pem"), filepath.Join(dir, "expired.key")
+	writeCertificate(t, expiredCert, expiredKey, "expired", time.Now().Add(-time.Hour))
+	validCert, validKey := filepath.Join(dir, "valid.pem"), filepath.Join(dir, "valid.key")
+	writeCertificate(t, validCert, validKey, "valid", time.Now().Add(time.Hour))
+	emptyCA := filepath.Join(dir, "empty.pem")
+	_ = os.WriteFile(emptyCA, []byte("not a certificate"), 0o600)
+
+	tests := map[string]*RelpDialer.RelpTLSFiles{
+		"expired certificate": {CertFile: expiredCert, KeyFile: expiredKey},
+		"mismatching key":     {CertFile: validCert, KeyFile: expiredKey},
+		"empty CA bundle":     {CAFile: emptyCA},
+		"missing CA bundle":   {CAFile: filepath.Join(dir, "missing.pem")},
+	}
+	for name, files := range tests {
+		err := files.Init()
+		var configErr *Errors.TLSConfigError
+		if !errors.As(err, &configErr) {
+			t.Errorf("Init with %v returned %v; want TLSConfigError", name, err)
+		}
+	}
+	valid := &RelpDialer.RelpTLSFiles{CertFile: validCert, KeyFile: validKey}
+	if err := valid.Init(); err != nil {
+		t.Errorf("Init with a valid certificate returned %v; want nil", err)
+	}
+}